@@ -2,9 +2,15 @@ package main
 
 import (
 	"atostechtest/internal/api"
+	"atostechtest/internal/config"
 	"atostechtest/internal/datastore"
+	"atostechtest/internal/encryption"
 	"atostechtest/internal/sessionstore"
+	"atostechtest/internal/tlsconfig"
+	"atostechtest/internal/tracing"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -12,33 +18,131 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
-)
 
-const (
-	port                    = 8081
-	gracefulShutdownTimeout = 20 * time.Second
-	maxSessionAge           = time.Minute * 10
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+// closableDB is a datastore which holds resources that must be released on
+// shutdown.
+type closableDB interface {
+	datastore.DB
+	Close()
+}
 
+func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	slog.SetDefault(logger)
 	logger = logger.With("component", "main")
 
-	db := datastore.NewInMemory(maxSessionAge)
-	sessionStore := sessionstore.New(db, maxSessionAge)
-	handlers := api.NewHTTPHandlers(sessionStore)
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if errors.Is(err, config.ErrInvalidConfig) {
+		logger.Error("loading configuration", "err", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		os.Exit(2) // Bad flags, already reported by the flag package.
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracingExporter)
+	if err != nil {
+		logger.Error("setting up tracing", "err", err)
+		os.Exit(1)
+	}
+
+	masterKey, previousMasterKeys, err := loadMasterKeys(cfg)
+	if err != nil {
+		logger.Error("loading master keys", "err", err)
+		os.Exit(1)
+	}
+	if masterKey == nil {
+		// Sessions in a persistent or shared datastore must be readable by
+		// every replica and after a restart, which a per-process key is not.
+		if cfg.Datastore != "memory" {
+			logger.Error("no master key configured, one is required for this datastore", "datastore", cfg.Datastore)
+			os.Exit(1)
+		}
+		logger.Warn("no master key configured, generating an ephemeral one")
+		masterKey, _ = encryption.GenerateKey(encryption.AES256GCM)
+	}
+
+	var db closableDB
+	switch cfg.Datastore {
+	case "memory":
+		db = datastore.NewInMemory(cfg.ExpiryPollInterval)
+	case "bolt":
+		db, err = datastore.NewBolt(cfg.BoltPath, cfg.ExpiryPollInterval)
+		if err != nil {
+			logger.Error("opening bolt datastore", "path", cfg.BoltPath, "err", err)
+			os.Exit(1)
+		}
+	case "redis":
+		db, err = datastore.NewRedis(cfg.RedisURL)
+		if err != nil {
+			logger.Error("connecting to redis datastore", "err", err)
+			os.Exit(1)
+		}
+	}
+	logger.Info("using datastore", "datastore", cfg.Datastore)
+	prometheus.MustRegister(datastore.NewActiveSessionsCollector(db))
+
+	sessionStore, err := sessionstore.New(datastore.Traced(db, cfg.Datastore), cfg.MaxSessionAge, masterKey, previousMasterKeys...)
+	if err != nil {
+		logger.Error("creating session store", "err", err)
+		os.Exit(1)
+	}
+	if len(previousMasterKeys) > 0 {
+		if _, err := sessionStore.RewrapSessions(ctx); err != nil {
+			logger.Error("rewrapping session keys", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	var apiKeys *api.APIKeys
+	if cfg.APIKeysFile != "" {
+		if apiKeys, err = api.LoadAPIKeys(cfg.APIKeysFile); err != nil {
+			logger.Error("loading API keys", "path", cfg.APIKeysFile, "err", err)
+			os.Exit(1)
+		}
+	} else {
+		logger.Warn("no API keys configured, authentication is disabled")
+	}
+	handlers := api.NewHTTPHandlers(sessionStore, apiKeys, api.Options{
+		RateLimit:              api.RateLimit{PerSecond: cfg.RateLimit, Burst: cfg.RateLimitBurst},
+		SessionCreateRateLimit: api.RateLimit{PerSecond: cfg.SessionCreateRateLimit, Burst: cfg.SessionCreateRateLimitBurst},
+		AuthFailureRateLimit:   api.RateLimit{PerSecond: cfg.AuthFailureRateLimit, Burst: cfg.AuthFailureRateLimitBurst},
+		MaxBatchSize:           cfg.MaxBatchSize,
+	})
 
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
 		Handler: handlers.Router,
 	}
+	if cfg.TLS.CertFile != "" {
+		certs, err := tlsconfig.New(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			logger.Error("loading TLS certificates", "err", err)
+			os.Exit(1)
+		}
+		defer certs.Close()
+		srv.TLSConfig = certs.TLSConfig()
+	}
 	go func() {
-		logger.Info("starting server", "port", port)
-		err := srv.ListenAndServe()
+		logger.Info("starting server",
+			"port", cfg.Port,
+			"tls", srv.TLSConfig != nil,
+			"mtls", cfg.TLS.ClientCAFile != "")
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "") // Certificates come from TLSConfig.
+		} else {
+			err = srv.ListenAndServe()
+		}
 		if err == http.ErrServerClosed {
 			logger.Info("server stopped")
 			return
@@ -56,13 +160,63 @@ func main() {
 
 	<-ctx.Done()
 	logger.Info("shutdown signal received")
+
+	// Report not ready, and give load balancers time to notice, before
+	// refusing new connections.
+	handlers.SetReady(false)
+	logger.Info("draining", "delay", cfg.ShutdownDrainDelay)
+	time.Sleep(cfg.ShutdownDrainDelay)
+
 	logger.Info("stopping server")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracefulShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.GracefulShutdownTimeout)
 	srv.Shutdown(shutdownCtx)
 	defer cancel()
 
 	// Called after server gracefully shutdown to allow for inflight requests
 	// to be successfully handled.
 	db.Close()
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), cfg.GracefulShutdownTimeout)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("flushing trace spans", "err", err)
+	}
+}
+
+// loadMasterKeys loads the current master key and any previous master keys,
+// each from the files or encoded keys configured. A nil master key is
+// returned if none is configured.
+func loadMasterKeys(cfg *config.Config) ([]byte, [][]byte, error) {
+	var (
+		masterKey []byte
+		previous  [][]byte
+		err       error
+	)
+
+	if cfg.MasterKeyFile != "" {
+		masterKey, err = sessionstore.LoadMasterKeyFile(cfg.MasterKeyFile)
+	} else if cfg.MasterKey != "" {
+		masterKey, err = sessionstore.ParseMasterKey(cfg.MasterKey)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, path := range cfg.PreviousMasterKeyFiles {
+		key, err := sessionstore.LoadMasterKeyFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		previous = append(previous, key)
+	}
+	for _, encoded := range cfg.PreviousMasterKeys {
+		key, err := sessionstore.ParseMasterKey(encoded)
+		if err != nil {
+			return nil, nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	return masterKey, previous, nil
 }
@@ -40,7 +40,666 @@ const docTemplate = `{
         },
         "/session": {
             "post": {
-                "description": "Create an encryption session associating a session with a specific algorithm and key.",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Create an encryption session associating a session with a specific algorithm and key.\nKeys may be supplied raw or encoded as base64, base64url or hex (see key_encoding); the key size is\nvalidated after decoding. If no key is supplied the server generates one of the correct size for the\nalgorithm. The generated key is returned, once, in the requested key_encoding (base64 by default)\nunless return_key is false (an encrypt-only session).\nAlternatively a passphrase may be supplied, from which the key is derived with argon2id (the default),\nscrypt or pbkdf2 as configured by kdf. The salt and parameters used are returned so that the same key\ncan be derived again; the derived key itself is not returned.\nThe session lifetime may be shortened with ttl_seconds, and its use limited with max_operations and\nmax_bytes; requests which would exceed a limit are rejected with a 400, and once a limit is reached\nthe session expires.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "encryption",
+                    "session"
+                ],
+                "summary": "Create encryption session.",
+                "parameters": [
+                    {
+                        "description": "Request body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.SessionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SessionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{session_id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Returns the algorithm, creation time, expiry time, usage limits and usage of an encryption session.\nThe session key is never returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "session"
+                ],
+                "summary": "Inspect encryption session.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SessionInfoResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Deletes an encryption session before it expires. Any further use of the session returns a 404.",
+                "tags": [
+                    "session"
+                ],
+                "summary": "Revoke encryption session.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{session_id}/decrypt": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Decrypt cipher text in the context of a specific encryption session.\nThe cipher will be decrypted using the specific algorithm and key associated with the session.\nFor authenticated (AEAD) algorithms a cipher text which fails authentication, including when the\nsupplied additional authenticated data does not match, is rejected with a 400.\nCipher texts carry a header naming the algorithm and key they were encrypted with, so any key in the\nsession's keyring is used as needed; one encrypted under another session's algorithm or key, or\nunder a different context, is rejected with a 400.\nThe cipher text is read as base64 unless ciphertext_encoding says otherwise. The plaintext is returned\nas utf8 unless output_encoding is base64 or hex; binary plaintexts must request one of these.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "encryption",
+                    "session"
+                ],
+                "summary": "Decrypt cipher text.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    },
+                    {
+                        "description": "Request body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.DecryptRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.DecryptResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{session_id}/decrypt/stream": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Decrypt an application/octet-stream request body produced by the encrypt stream endpoint in the\ncontext of a specific encryption session. Plaintext is streamed back as each segment is\nauthenticated. If the stream is found to be tampered with or truncated after output has begun the\nconnection is aborted, so clients must treat an incomplete response as a failure.\nStreams record the ID of the key they were encrypted with, so they can still be decrypted after a key\nrotation.",
+                "consumes": [
+                    "application/octet-stream"
+                ],
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "encryption",
+                    "session",
+                    "stream"
+                ],
+                "summary": "Decrypt a stream.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The context label given at encryption time",
+                        "name": "context",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Framed cipher text bytes",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{session_id}/decrypt:batch": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Decrypt every item in the context of a specific encryption session, as the decrypt endpoint does for\none. A result is returned for every item, in order, holding either its plaintext or the error which\nprevented it being decrypted, such as failed authentication; one item failing does not affect the\nothers. The number of items is limited by a server configured maximum (1000 by default); larger\nbatches are rejected with a 400. Every item counts towards the session's usage limits; a batch which\nwould exceed them is rejected with a 400 before any item is decrypted.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "encryption",
+                    "session",
+                    "batch"
+                ],
+                "summary": "Decrypt a batch of cipher texts.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    },
+                    {
+                        "description": "Request body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.DecryptBatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.DecryptBatchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{session_id}/encrypt": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Encrypt plaintext in the context of a specific encryption session.\nThe plaintext will be encrypted using the specific algorithm and key associated with the session.\nAdditional authenticated data may be supplied for authenticated (AEAD) algorithms only.\nA context label may be supplied to encrypt under a sub-key of the session key derived for that context\nwith HKDF; the cipher text can then only be decrypted under the same context.\nBinary plaintexts may be supplied base64 or hex encoded (see plaintext_encoding). The cipher text is\nreturned base64 encoded unless output_encoding is hex.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "encryption",
+                    "session"
+                ],
+                "summary": "Encrypt plaintext.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    },
+                    {
+                        "description": "Request body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.EncryptRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.EncryptResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{session_id}/encrypt/stream": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Encrypt an application/octet-stream request body in the context of a specific encryption session.\nThe body is encrypted chunk by chunk and streamed back as binary framed cipher text, so arbitrarily\nlarge inputs are handled in constant memory. Only authenticated (AEAD) algorithms support streaming.\nThe output can only be decrypted by the decrypt stream endpoint.",
+                "consumes": [
+                    "application/octet-stream"
+                ],
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "encryption",
+                    "session",
+                    "stream"
+                ],
+                "summary": "Encrypt a stream.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    },
+                    {
+                        "type": "string",
+                        "description": "A context label to encrypt under a sub-key for",
+                        "name": "context",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Plaintext bytes",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{session_id}/encrypt:batch": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Encrypt every item in the context of a specific encryption session, as the encrypt endpoint does for\none. A result is returned for every item, in order, holding either its cipher text or the error which\nprevented it being encrypted; one item failing does not affect the others. The number of items is\nlimited by a server configured maximum (1000 by default); larger batches are rejected with a 400.\nEvery item counts towards the session's usage limits; a batch which would exceed them is rejected\nwith a 400 before any item is encrypted.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "encryption",
+                    "session",
+                    "batch"
+                ],
+                "summary": "Encrypt a batch of plaintexts.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    },
+                    {
+                        "description": "Request body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.EncryptBatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.EncryptBatchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{session_id}/keys": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "A session holds a keyring: a primary key, used to encrypt, and up to 15 further keys which can only\ndecrypt. Every cipher text records the ID of the key it was encrypted with, so cipher texts encrypted\nunder any key held can still be decrypted after a rotation.\nSupply key to add a key (or leave both key and key_id out to have the server generate one, which is\nreturned once unless return_key is false), with promote set to make it the primary key. Supply key_id\ninstead to promote a key the session already holds. Adding a key which is already held is not an\nerror.",
                 "consumes": [
                     "application/json"
                 ],
@@ -48,18 +707,23 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "encryption",
                     "session"
                 ],
-                "summary": "Create encryption session.",
+                "summary": "Add or promote a session key.",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "An encryption session ID",
+                        "name": "session_id",
+                        "in": "path"
+                    },
                     {
                         "description": "Request body",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.SessionRequest"
+                            "$ref": "#/definitions/api.KeyRequest"
                         }
                     }
                 ],
@@ -67,7 +731,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.SessionResponse"
+                            "$ref": "#/definitions/api.KeyResponse"
                         }
                     },
                     "400": {
@@ -76,6 +740,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -85,9 +767,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/session/{session_id}/decrypt": {
+        "/session/{session_id}/reencrypt": {
             "post": {
-                "description": "Decrypt cipher text in the context of a specific encryption session.\nThe cipher will be decrypted using the specific algorithm and key associated with the session.",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Decrypt a cipher text in the context of the source session and encrypt the result in the context of\nthe target session, returning the new cipher text. The plaintext never leaves the service, which\nmakes this suitable for migrating data between algorithms or keys; giving the source session as the\ntarget re-encrypts under its primary key. Both sessions must belong to the caller. The operation\ncounts as a decrypt against the source session and an encrypt against the target session.\nThe cipher text is read as base64 unless ciphertext_encoding says otherwise, and returned base64\nencoded unless output_encoding is hex.",
                 "consumes": [
                     "application/json"
                 ],
@@ -98,11 +785,11 @@ const docTemplate = `{
                     "encryption",
                     "session"
                 ],
-                "summary": "Decrypt cipher text.",
+                "summary": "Re-encrypt cipher text under another session.",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "An encryption session ID",
+                        "description": "The source encryption session ID",
                         "name": "session_id",
                         "in": "path"
                     },
@@ -112,7 +799,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.DecryptRequest"
+                            "$ref": "#/definitions/api.ReencryptRequest"
                         }
                     }
                 ],
@@ -120,7 +807,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.DecryptResponse"
+                            "$ref": "#/definitions/api.EncryptResponse"
                         }
                     },
                     "400": {
@@ -129,12 +816,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/api.ErrResponse"
                         }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -144,46 +843,38 @@ const docTemplate = `{
                 }
             }
         },
-        "/session/{session_id}/encrypt": {
+        "/session/{session_id}/refresh": {
             "post": {
-                "description": "Encrypt plaintext in the context of a specific encryption session.\nThe plaintext will be encrypted using the specific algorithm and key associated with the session.",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
                 ],
+                "description": "Resets the expiry of an encryption session so that it expires its ttl_seconds from now (sliding\nexpiry). Usage limits are not reset. Expired or exhausted sessions cannot be refreshed.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "encryption",
                     "session"
                 ],
-                "summary": "Encrypt plaintext.",
+                "summary": "Refresh encryption session.",
                 "parameters": [
                     {
                         "type": "string",
                         "description": "An encryption session ID",
                         "name": "session_id",
                         "in": "path"
-                    },
-                    {
-                        "description": "Request body",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.EncryptRequest"
-                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.EncryptResponse"
+                            "$ref": "#/definitions/api.SessionInfoResponse"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/api.ErrResponse"
                         }
@@ -194,6 +885,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrResponse"
                         }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -218,18 +915,70 @@ const docTemplate = `{
                 }
             }
         },
+        "api.DecryptBatchRequest": {
+            "description": "Used for decrypting many cipher texts under a given session context in one request.",
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "The cipher texts to decrypt, each as for the decrypt endpoint.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.DecryptRequest"
+                    }
+                }
+            }
+        },
+        "api.DecryptBatchResponse": {
+            "description": "Contains a result for every item in the request, in the same order.",
+            "type": "object",
+            "properties": {
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.DecryptBatchResult"
+                    }
+                }
+            }
+        },
+        "api.DecryptBatchResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/api.ErrResponse"
+                },
+                "plaintext": {
+                    "type": "string"
+                }
+            }
+        },
         "api.DecryptRequest": {
             "description": "Used for decrypted cipher text under a given session context.",
             "type": "object",
             "properties": {
+                "aad": {
+                    "description": "The additional authenticated data given at encryption time, if any.",
+                    "type": "string"
+                },
                 "ciphertext": {
                     "description": "The cipher text to decrypt.",
                     "type": "string"
+                },
+                "ciphertext_encoding": {
+                    "description": "The encoding of ciphertext: one of base64 (the default) or hex.",
+                    "type": "string"
+                },
+                "context": {
+                    "description": "The context label given at encryption time, if any.",
+                    "type": "string"
+                },
+                "output_encoding": {
+                    "description": "The encoding of the returned plaintext: one of utf8 (the default), base64 or hex.",
+                    "type": "string"
                 }
             }
         },
         "api.DecryptResponse": {
-            "description": "Contains successfully decrypted message as plaintext.",
+            "description": "Contains successfully decrypted message as plaintext, encoded as requested by output_encoding.",
             "type": "object",
             "properties": {
                 "plaintext": {
@@ -237,18 +986,70 @@ const docTemplate = `{
                 }
             }
         },
+        "api.EncryptBatchRequest": {
+            "description": "Used for encrypting many plaintexts under a given session context in one request.",
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "The plaintexts to encrypt, each as for the encrypt endpoint.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.EncryptRequest"
+                    }
+                }
+            }
+        },
+        "api.EncryptBatchResponse": {
+            "description": "Contains a result for every item in the request, in the same order.",
+            "type": "object",
+            "properties": {
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.EncryptBatchResult"
+                    }
+                }
+            }
+        },
+        "api.EncryptBatchResult": {
+            "type": "object",
+            "properties": {
+                "cipher_text": {
+                    "type": "string"
+                },
+                "error": {
+                    "$ref": "#/definitions/api.ErrResponse"
+                }
+            }
+        },
         "api.EncryptRequest": {
             "description": "Used for encrypting plaintext under a given session context.",
             "type": "object",
             "properties": {
+                "aad": {
+                    "description": "Optional additional authenticated data (AEAD algorithms only). It is\nnot encrypted but must be supplied again, unchanged, to decrypt.",
+                    "type": "string"
+                },
+                "context": {
+                    "description": "Optional context label. The plaintext is encrypted under a sub-key of\nthe session key derived for this context, and the same context must be\nsupplied to decrypt.",
+                    "type": "string"
+                },
+                "output_encoding": {
+                    "description": "The encoding of the returned cipher text: one of base64 (the default)\nor hex.",
+                    "type": "string"
+                },
                 "plaintext": {
-                    "description": "The plaintext to encrypt.",
+                    "description": "The plaintext to encrypt, encoded as described by plaintext_encoding.",
+                    "type": "string"
+                },
+                "plaintext_encoding": {
+                    "description": "The encoding of plaintext: one of utf8 (the default), base64 or hex.\nUse base64 or hex for binary data.",
                     "type": "string"
                 }
             }
         },
         "api.EncryptResponse": {
-            "description": "Contains successfully encrypted message base64 encoded as cipher text.",
+            "description": "Contains successfully encrypted message encoded (base64 by default) as cipher text.",
             "type": "object",
             "properties": {
                 "cipher_text": {
@@ -270,6 +1071,190 @@ const docTemplate = `{
                 }
             }
         },
+        "api.KDFParams": {
+            "description": "Key derivation function and parameters. Omitted parameters take the defaults for the function.",
+            "type": "object",
+            "properties": {
+                "iterations": {
+                    "description": "PBKDF2: the number of iterations (default 600000).",
+                    "type": "integer"
+                },
+                "memory_kib": {
+                    "description": "Argon2id: the memory used in KiB (default 19456, at most 65536).",
+                    "type": "integer"
+                },
+                "n": {
+                    "description": "scrypt: the CPU and memory cost, a power of two (default 131072).",
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "The key derivation function: one of argon2id (the default), scrypt or\npbkdf2 (HMAC-SHA-256).",
+                    "type": "string"
+                },
+                "p": {
+                    "description": "scrypt: the parallelisation (default 1, at most 4).",
+                    "type": "integer"
+                },
+                "r": {
+                    "description": "scrypt: the block size (default 8).",
+                    "type": "integer"
+                },
+                "salt": {
+                    "description": "The salt, base64 encoded and at least 8 bytes long. A random 16 byte\nsalt is generated if omitted.",
+                    "type": "string"
+                },
+                "threads": {
+                    "description": "Argon2id: the degree of parallelism (default 1, at most 4).",
+                    "type": "integer"
+                },
+                "time": {
+                    "description": "Argon2id: the number of passes over memory (default 2, at most 16).",
+                    "type": "integer"
+                }
+            }
+        },
+        "api.KeyRequest": {
+            "description": "Used for adding a key to, or promoting a key within, the keyring of an encryption session.",
+            "type": "object",
+            "properties": {
+                "key": {
+                    "description": "The key to add. If neither key nor key_id is supplied the server\ngenerates a random key of the correct size for the session algorithm.",
+                    "type": "string"
+                },
+                "key_encoding": {
+                    "description": "The encoding of key: one of raw (the default), base64, base64url or\nhex. A generated key is returned in this encoding, with raw falling\nback to base64.",
+                    "type": "string"
+                },
+                "key_id": {
+                    "description": "The ID of a key the session already holds, to make it the primary key.\nCannot be combined with key.",
+                    "type": "string"
+                },
+                "promote": {
+                    "description": "Whether an added key becomes the primary key, used for all further\nencryption. Defaults to false, leaving the key usable for decryption\nonly until it is promoted.",
+                    "type": "boolean"
+                },
+                "return_key": {
+                    "description": "Whether a server generated key is returned in the response. Defaults\nto true. Ignored when a key is supplied.",
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.KeyResponse": {
+            "description": "Describes the keyring of an encryption session after a key was added or promoted.",
+            "type": "object",
+            "properties": {
+                "key": {
+                    "description": "The server generated key, encoded as requested by key_encoding. Only\npresent when a key was generated and never returned again.",
+                    "type": "string"
+                },
+                "key_id": {
+                    "description": "The ID of the key added or promoted.",
+                    "type": "string"
+                },
+                "key_ids": {
+                    "description": "The IDs of every key held, primary key first.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "primary_key_id": {
+                    "description": "The ID of the key used to encrypt.",
+                    "type": "string"
+                }
+            }
+        },
+        "api.ReencryptRequest": {
+            "description": "Used for moving a cipher text from one session to another without the plaintext leaving the service.",
+            "type": "object",
+            "properties": {
+                "aad": {
+                    "description": "The additional authenticated data given at encryption time, if any.",
+                    "type": "string"
+                },
+                "ciphertext": {
+                    "description": "The cipher text to re-encrypt, under the source session.",
+                    "type": "string"
+                },
+                "ciphertext_encoding": {
+                    "description": "The encoding of ciphertext: one of base64 (the default) or hex.",
+                    "type": "string"
+                },
+                "context": {
+                    "description": "The context label given at encryption time, if any.",
+                    "type": "string"
+                },
+                "output_encoding": {
+                    "description": "The encoding of the returned cipher text: one of base64 (the default) or hex.",
+                    "type": "string"
+                },
+                "target_aad": {
+                    "description": "Additional authenticated data for the new cipher text (AEAD algorithms only), if any.",
+                    "type": "string"
+                },
+                "target_context": {
+                    "description": "The context label to encrypt the new cipher text under, if any.",
+                    "type": "string"
+                },
+                "target_session_id": {
+                    "description": "The ID of the session to re-encrypt under.",
+                    "type": "string"
+                }
+            }
+        },
+        "api.SessionInfoResponse": {
+            "description": "Describes an encryption session. The session keys are never included, only their IDs.",
+            "type": "object",
+            "properties": {
+                "algorithm": {
+                    "description": "The algorithm associated with the session.",
+                    "type": "string"
+                },
+                "bytes_processed": {
+                    "description": "The number of input bytes encrypted or decrypted.",
+                    "type": "integer"
+                },
+                "created_at": {
+                    "description": "When the session was created.",
+                    "type": "string"
+                },
+                "expires_at": {
+                    "description": "When the session expires unless refreshed.",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "The session ID.",
+                    "type": "string"
+                },
+                "key_ids": {
+                    "description": "The IDs of every key held, primary key first.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "max_bytes": {
+                    "description": "The byte limit, if any.",
+                    "type": "integer"
+                },
+                "max_operations": {
+                    "description": "The operation limit, if any.",
+                    "type": "integer"
+                },
+                "primary_key_id": {
+                    "description": "The ID of the key used to encrypt.",
+                    "type": "string"
+                },
+                "ttl_seconds": {
+                    "description": "The session lifetime, applied again on refresh.",
+                    "type": "integer"
+                },
+                "usage_count": {
+                    "description": "The number of successful encrypt and decrypt operations.",
+                    "type": "integer"
+                }
+            }
+        },
         "api.SessionRequest": {
             "description": "Used for configuring and creating a new encryption session.",
             "type": "object",
@@ -278,9 +1263,41 @@ const docTemplate = `{
                     "description": "The Algorithm to associate with this session.",
                     "type": "string"
                 },
+                "kdf": {
+                    "description": "How the key is derived from passphrase. Defaults to argon2id with its\ndefault parameters.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.KDFParams"
+                        }
+                    ]
+                },
                 "key": {
-                    "description": "The key to associate with this session.",
+                    "description": "The key to associate with this session. If omitted the server\ngenerates a random key of the correct size for the algorithm.",
+                    "type": "string"
+                },
+                "key_encoding": {
+                    "description": "The encoding of key: one of raw (the default), base64, base64url or\nhex. A generated key is returned in this encoding, with raw falling\nback to base64.",
+                    "type": "string"
+                },
+                "max_bytes": {
+                    "description": "The maximum number of input bytes encrypted or decrypted, after which\nthe session expires. Requests which would exceed it are rejected.\nUnlimited if omitted.",
+                    "type": "integer"
+                },
+                "max_operations": {
+                    "description": "The maximum number of encrypt and decrypt operations, after which the\nsession expires. Requests which would exceed it are rejected. Unlimited\nif omitted.",
+                    "type": "integer"
+                },
+                "passphrase": {
+                    "description": "A passphrase to derive the session key from, instead of supplying a\nkey. Cannot be combined with key.",
                     "type": "string"
+                },
+                "return_key": {
+                    "description": "Whether a server generated key is returned in the response. Defaults\nto true; set to false for encrypt-only sessions where the key must\nnever leave the service. Ignored when a key is supplied.",
+                    "type": "boolean"
+                },
+                "ttl_seconds": {
+                    "description": "The session lifetime in seconds. Defaults to, and is capped at, the\nserver's maximum session age.",
+                    "type": "integer"
                 }
             }
         },
@@ -288,12 +1305,36 @@ const docTemplate = `{
             "description": "Contains the session ID which can be used in calls to encrypt and decrypt input.",
             "type": "object",
             "properties": {
+                "expires_at": {
+                    "description": "When the session expires unless refreshed.",
+                    "type": "string"
+                },
                 "id": {
                     "description": "The session ID.",
                     "type": "string"
+                },
+                "kdf": {
+                    "description": "How the key was derived from the passphrase, salt included. Only\npresent on creation of a session from a passphrase; supply it again\nwith the same passphrase to derive the same key.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.KDFParams"
+                        }
+                    ]
+                },
+                "key": {
+                    "description": "The server generated key, encoded as requested by key_encoding. Only\npresent on creation of a session with a generated key and never\nreturned again.",
+                    "type": "string"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "description": "An API key, which may instead be sent as an Authorization: Bearer token.",
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        }
     }
 }`
 
@@ -304,7 +1345,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/api/v1",
 	Schemes:          []string{},
 	Title:            "Richard Merry ATOS Tech Test",
-	Description:      "A simple API for creating symmetric encryption sessions within which plaintext can be encrypted and cipher text decrypted. Sessions have a limited lifetime, currently set to 10 minutes.",
+	Description:      "A simple API for creating symmetric encryption sessions within which plaintext can be encrypted and cipher text decrypted. Sessions have a limited lifetime, capped by a server configured maximum (10 minutes by default), and may also be limited in use.",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
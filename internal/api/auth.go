@@ -0,0 +1,152 @@
+package api
+
+import (
+	"atostechtest/internal/tlsconfig"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+// apiKeyHeader is the header an API key may be sent in, as an alternative to
+// an Authorization: Bearer header.
+const apiKeyHeader = "X-API-Key"
+
+var (
+	// ErrInvalidAPIKeysFile is returned when an API keys file cannot be
+	// parsed or contains an invalid entry.
+	ErrInvalidAPIKeysFile = errors.New("invalid API keys file")
+)
+
+// APIKey associates an API key, or bearer token, with the principal it
+// authenticates. Either the key itself or its hex encoded SHA-256 digest may
+// be configured, the latter so that keys need not be stored in the clear.
+type APIKey struct {
+	Principal string `json:"principal"`
+	Key       string `json:"key,omitempty"`
+	KeySHA256 string `json:"key_sha256,omitempty"`
+}
+
+// APIKeys authenticates requests by API key. The zero value is not ready to
+// use, create new instances with the NewAPIKeys() or LoadAPIKeys() functions.
+type APIKeys struct {
+	principals map[[sha256.Size]byte]string // Keyed by key digest.
+}
+
+// NewAPIKeys returns an APIKeys which accepts the given keys. Every key must
+// name a principal and exactly one of its key or digest.
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	a := &APIKeys{principals: make(map[[sha256.Size]byte]string)}
+	for i, k := range keys {
+		if strings.TrimSpace(k.Principal) == "" {
+			return nil, fmt.Errorf("%w: entry %d has no principal", ErrInvalidAPIKeysFile, i)
+		}
+
+		var digest [sha256.Size]byte
+		switch {
+		case k.Key != "" && k.KeySHA256 == "":
+			digest = sha256.Sum256([]byte(k.Key))
+		case k.Key == "" && k.KeySHA256 != "":
+			b, err := hex.DecodeString(k.KeySHA256)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("%w: entry %d has an invalid key_sha256", ErrInvalidAPIKeysFile, i)
+			}
+			copy(digest[:], b)
+		default:
+			return nil, fmt.Errorf("%w: entry %d must have one of key or key_sha256", ErrInvalidAPIKeysFile, i)
+		}
+
+		if _, exists := a.principals[digest]; exists {
+			return nil, fmt.Errorf("%w: entry %d duplicates an earlier key", ErrInvalidAPIKeysFile, i)
+		}
+		a.principals[digest] = k.Principal
+	}
+
+	return a, nil
+}
+
+// LoadAPIKeys reads API keys from a JSON file of the form:
+//
+//	{"api_keys": [{"principal": "etl", "key_sha256": "9f86d0..."}]}
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		APIKeys []APIKey `json:"api_keys"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAPIKeysFile, err)
+	}
+
+	return NewAPIKeys(file.APIKeys)
+}
+
+// Authenticate returns the principal associated with key, or false if the key
+// is not recognised. Keys are compared by digest so the comparison does not
+// leak the configured keys through timing.
+func (a *APIKeys) Authenticate(key string) (string, bool) {
+	principal, ok := a.principals[sha256.Sum256([]byte(key))]
+	return principal, ok
+}
+
+// authenticate is middleware which requires requests to carry a valid API key,
+// either as a bearer token or in the X-API-Key header, and puts the
+// authenticated principal on the request context. If API keys are not
+// configured the identity of the verified TLS client certificate is used as
+// the principal, which is the anonymous principal "" if there is none.
+// Clients whose failed attempts exceed the auth failure rate limit are
+// rejected with a 429, whatever key they send.
+func (h *Handlers) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.apiKeys == nil {
+			ctx := context.WithValue(r.Context(), "principal", tlsconfig.ClientIdentity(r.TLS))
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		key := r.Header.Get(apiKeyHeader)
+		if scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " "); found &&
+			strings.EqualFold(scheme, "Bearer") {
+			key = strings.TrimSpace(token)
+		}
+
+		// Failed attempts are limited per IP address, checked before the key
+		// so that a client guessing keys learns nothing once limited.
+		if l := h.authFailureLimiter; l != nil {
+			if retryAfter := l.wait(remoteIPKey(r)); retryAfter > 0 {
+				tooManyRequests(w, r, l, retryAfter)
+				return
+			}
+		}
+
+		principal, ok := h.apiKeys.Authenticate(key)
+		if key == "" || !ok {
+			if h.authFailureLimiter != nil && key != "" {
+				h.authFailureLimiter.allow(remoteIPKey(r))
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			render.Render(w, r, ErrUnauthorized())
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "principal", principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// principal returns the principal authenticated for a request, which is
+// empty when authentication is not configured.
+func principal(r *http.Request) string {
+	p, _ := r.Context().Value("principal").(string)
+	return p
+}
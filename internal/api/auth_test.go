@@ -0,0 +1,190 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestAPIKeys(t *testing.T) *APIKeys {
+	t.Helper()
+	digest := sha256.Sum256([]byte("billing-key"))
+	keys, err := NewAPIKeys([]APIKey{
+		{Principal: "etl", Key: "etl-key"},
+		{Principal: "billing", KeySHA256: hex.EncodeToString(digest[:])},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating API keys: %v", err)
+	}
+	return keys
+}
+
+func TestNewAPIKeys(t *testing.T) {
+	testCases := []struct {
+		name string
+		keys []APIKey
+	}{
+		{"No principal", []APIKey{{Key: "etl-key"}}},
+		{"No key", []APIKey{{Principal: "etl"}}},
+		{"Key and digest", []APIKey{{Principal: "etl", Key: "etl-key", KeySHA256: "00"}}},
+		{"Invalid digest", []APIKey{{Principal: "etl", KeySHA256: "not hex"}}},
+		{"Duplicate key", []APIKey{{Principal: "etl", Key: "etl-key"}, {Principal: "billing", Key: "etl-key"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewAPIKeys(tc.keys); !errors.Is(err, ErrInvalidAPIKeysFile) {
+				t.Errorf("expected ErrInvalidAPIKeysFile, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`{"api_keys": [{"principal": "etl", "key": "etl-key"}]}`), 0o600)
+
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p, ok := keys.Authenticate("etl-key"); !ok || p != "etl" {
+		t.Errorf("expected principal %q, got %q", "etl", p)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	h := newTestHandlers(t, newTestAPIKeys(t), Options{})
+	body := map[string]any{"algorithm": "aes128-gcm"}
+
+	testCases := []struct {
+		name   string
+		header []string
+		status int
+	}{
+		{"No key", nil, http.StatusUnauthorized},
+		{"Unknown key", []string{apiKeyHeader, "guess"}, http.StatusUnauthorized},
+		{"Unknown bearer token", []string{"Authorization", "Bearer guess"}, http.StatusUnauthorized},
+		{"API key header", []string{apiKeyHeader, "etl-key"}, http.StatusCreated},
+		{"Bearer token", []string{"Authorization", "Bearer etl-key"}, http.StatusCreated},
+		{"Bearer token matching a digest", []string{"Authorization", "bearer billing-key"}, http.StatusCreated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := do(h, http.MethodPost, sessionPath, body, tc.header...)
+			if w.Code != tc.status {
+				t.Errorf("expected %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if tc.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate header")
+			}
+		})
+	}
+
+	t.Run("Algorithms are public", func(t *testing.T) {
+		if w := do(h, http.MethodGet, "/api/v1/algorithms/", nil); w.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", w.Code)
+		}
+	})
+}
+
+func TestSessionOwnership(t *testing.T) {
+	h := newTestHandlers(t, newTestAPIKeys(t), Options{})
+	id := newSession(t, h, map[string]any{"algorithm": "aes128-gcm"}, apiKeyHeader, "etl-key")
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+	}{
+		{"Inspect", http.MethodGet, "", nil},
+		{"Encrypt", http.MethodPost, "/encrypt", map[string]any{"plaintext": "Ah-nold"}},
+		{"Refresh", http.MethodPost, "/refresh", nil},
+		{"Revoke", http.MethodDelete, "", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := do(h, tc.method, sessionPath+id+tc.path, tc.body, apiKeyHeader, "billing-key")
+			if w.Code != http.StatusNotFound {
+				t.Errorf("expected another principal's session to be hidden with a 404, got %d", w.Code)
+			}
+		})
+	}
+
+	t.Run("Owner", func(t *testing.T) {
+		if w := do(h, http.MethodGet, sessionPath+id, nil, apiKeyHeader, "etl-key"); w.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Re-encrypt under another principal's session", func(t *testing.T) {
+		other := newSession(t, h, map[string]any{"algorithm": "aes128-gcm"}, apiKeyHeader, "billing-key")
+		var encrypted EncryptResponse
+		decode(t, do(h, http.MethodPost, sessionPath+id+"/encrypt", map[string]any{"plaintext": "Ah-nold"}, apiKeyHeader, "etl-key"), &encrypted)
+
+		w := do(h, http.MethodPost, sessionPath+id+"/reencrypt",
+			map[string]any{"ciphertext": encrypted.CipherText, "target_session_id": other},
+			apiKeyHeader, "etl-key")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestClientCertificateIdentity(t *testing.T) {
+	h := newTestHandlers(t, nil, Options{})
+
+	// withClientCert returns a request from a client which presented a
+	// verified certificate with the given common name.
+	withClientCert := func(req *http.Request, cn string) *http.Request {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+		return req
+	}
+
+	w := serve(h, withClientCert(newRequest(http.MethodPost, sessionPath, map[string]any{"algorithm": "aes128-gcm"}), "etl"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created SessionResponse
+	decode(t, w, &created)
+
+	t.Run("Same certificate", func(t *testing.T) {
+		if w := serve(h, withClientCert(newRequest(http.MethodGet, sessionPath+created.ID, nil), "etl")); w.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", w.Code)
+		}
+	})
+
+	t.Run("Other certificate", func(t *testing.T) {
+		if w := serve(h, withClientCert(newRequest(http.MethodGet, sessionPath+created.ID, nil), "billing")); w.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("No certificate", func(t *testing.T) {
+		if w := do(h, http.MethodGet, sessionPath+created.ID, nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("Unverified certificate", func(t *testing.T) {
+		req := withClientCert(newRequest(http.MethodGet, sessionPath+created.ID, nil), "etl")
+		req.TLS.VerifiedChains = nil
+		if w := serve(h, req); w.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", w.Code)
+		}
+	})
+}
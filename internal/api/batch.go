@@ -0,0 +1,180 @@
+package api
+
+import (
+	"atostechtest/internal/sessionstore"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultMaxBatchSize is the maximum number of items in a batch request when
+// none is configured.
+const DefaultMaxBatchSize = 1000
+
+// Encrypts many plaintexts in a single request.
+//
+//	@Summary		Encrypt a batch of plaintexts.
+//	@Description	Encrypt every item in the context of a specific encryption session, as the encrypt endpoint does for
+//	@Description	one. A result is returned for every item, in order, holding either its cipher text or the error which
+//	@Description	prevented it being encrypted; one item failing does not affect the others. The number of items is
+//	@Description	limited by a server configured maximum (1000 by default); larger batches are rejected with a 400.
+//	@Description	Every item counts towards the session's usage limits; a batch which would exceed them is rejected
+//	@Description	with a 400 before any item is encrypted.
+//	@Tags			encryption, session, batch
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		string				false	"An encryption session ID"
+//	@Param			request		body		EncryptBatchRequest	true	"Request body"
+//	@Success		200			{object}	EncryptBatchResponse
+//	@Failure		400			{object}	ErrResponse
+//	@Failure		401			{object}	ErrResponse
+//	@Failure		404			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
+//	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}/encrypt:batch   [post]
+func (h *Handlers) createEncryptBatch(w http.ResponseWriter, r *http.Request) {
+	data := &EncryptBatchRequest{}
+	if err := bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err := h.checkBatchSize(len(data.Items)); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// Every item which binds is reserved against the session's usage
+	// limits up front, so a batch cannot run past them.
+	s := r.Context().Value("session").(*sessionstore.Session)
+	results := make([]EncryptBatchResult, len(data.Items))
+	var reserved, used sessionstore.Usage
+	for i := range data.Items {
+		item := &data.Items[i]
+		if err := item.Bind(r); err != nil {
+			results[i].Error = ErrInvalidRequest(err)
+			continue
+		}
+		reserved.Operations++
+		reserved.Bytes += int64(len(item.plaintext))
+	}
+	if reserved.Operations > 0 && !h.reserveUsage(w, r, s, reserved) {
+		return
+	}
+
+	_, span := tracer.Start(r.Context(), "encryption.EncryptBatch", batchSpanAttributes(s, len(data.Items)))
+	for i := range data.Items {
+		if results[i].Error != nil {
+			continue
+		}
+		item := &data.Items[i]
+		encoded, errResp := h.encrypt(s, item)
+		if errResp != nil {
+			results[i].Error = errResp
+			continue
+		}
+		results[i].CipherText = encoded
+		used.Operations++
+		used.Bytes += int64(len(item.plaintext))
+	}
+	span.End()
+
+	if reserved.Operations > 0 {
+		h.recordUsage(r.Context(), s, "encrypt", reserved, used)
+	}
+
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &EncryptBatchResponse{Results: results})
+}
+
+// Decrypts many cipher texts in a single request.
+//
+//	@Summary		Decrypt a batch of cipher texts.
+//	@Description	Decrypt every item in the context of a specific encryption session, as the decrypt endpoint does for
+//	@Description	one. A result is returned for every item, in order, holding either its plaintext or the error which
+//	@Description	prevented it being decrypted, such as failed authentication; one item failing does not affect the
+//	@Description	others. The number of items is limited by a server configured maximum (1000 by default); larger
+//	@Description	batches are rejected with a 400. Every item counts towards the session's usage limits; a batch which
+//	@Description	would exceed them is rejected with a 400 before any item is decrypted.
+//	@Tags			encryption, session, batch
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		string				false	"An encryption session ID"
+//	@Param			request		body		DecryptBatchRequest	true	"Request body"
+//	@Success		200			{object}	DecryptBatchResponse
+//	@Failure		400			{object}	ErrResponse
+//	@Failure		401			{object}	ErrResponse
+//	@Failure		404			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
+//	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}/decrypt:batch   [post]
+func (h *Handlers) createDecryptBatch(w http.ResponseWriter, r *http.Request) {
+	data := &DecryptBatchRequest{}
+	if err := bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err := h.checkBatchSize(len(data.Items)); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	s := r.Context().Value("session").(*sessionstore.Session)
+	results := make([]DecryptBatchResult, len(data.Items))
+	var reserved, used sessionstore.Usage
+	for i := range data.Items {
+		item := &data.Items[i]
+		if err := item.Bind(r); err != nil {
+			results[i].Error = ErrInvalidRequest(err)
+			continue
+		}
+		reserved.Operations++
+		reserved.Bytes += int64(len(item.cipherText))
+	}
+	if reserved.Operations > 0 && !h.reserveUsage(w, r, s, reserved) {
+		return
+	}
+
+	_, span := tracer.Start(r.Context(), "encryption.DecryptBatch", batchSpanAttributes(s, len(data.Items)))
+	for i := range data.Items {
+		if results[i].Error != nil {
+			continue
+		}
+		item := &data.Items[i]
+		encoded, errResp := h.decrypt(s, item)
+		if errResp != nil {
+			results[i].Error = errResp
+			continue
+		}
+		results[i].Plaintext = encoded
+		used.Operations++
+		used.Bytes += int64(len(item.cipherText))
+	}
+	span.End()
+
+	if reserved.Operations > 0 {
+		h.recordUsage(r.Context(), s, "decrypt", reserved, used)
+	}
+
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &DecryptBatchResponse{Results: results})
+}
+
+// checkBatchSize returns an error if a batch has more items than allowed.
+func (h *Handlers) checkBatchSize(n int) error {
+	if n > h.maxBatchSize {
+		return fmt.Errorf("batch of %d items exceeds the maximum of %d", n, h.maxBatchSize)
+	}
+	return nil
+}
+
+func batchSpanAttributes(s *sessionstore.Session, size int) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("session.algorithm", s.AlgorithmName),
+		attribute.Int("batch.size", size),
+	)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEncryptBatch_UsageLimits(t *testing.T) {
+	h := newTestHandlers(t, nil, Options{})
+
+	items := func(n int, plaintext string) map[string]any {
+		batch := make([]map[string]any, n)
+		for i := range batch {
+			batch[i] = map[string]any{"plaintext": plaintext}
+		}
+		return map[string]any{"items": batch}
+	}
+
+	t.Run("Too many operations", func(t *testing.T) {
+		id := newSession(t, h, map[string]any{"algorithm": "aes128-gcm", "max_operations": 1})
+		w := do(h, http.MethodPost, sessionPath+id+"/encrypt:batch", items(50, "Ah-nold"))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+		}
+
+		// Nothing was counted, so the session can still be used.
+		if w := do(h, http.MethodPost, sessionPath+id+"/encrypt:batch", items(1, "Ah-nold")); w.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := do(h, http.MethodGet, sessionPath+id, nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected exhausted session to be gone, got %d", w.Code)
+		}
+	})
+
+	t.Run("Too many bytes", func(t *testing.T) {
+		id := newSession(t, h, map[string]any{"algorithm": "aes128-gcm", "max_bytes": 100})
+		w := do(h, http.MethodPost, sessionPath+id+"/encrypt:batch", items(3, strings.Repeat("a", 40)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Failed items are not counted", func(t *testing.T) {
+		id := newSession(t, h, map[string]any{"algorithm": "aes128", "max_operations": 3})
+		batch := items(2, "Ah-nold")
+		batch["items"] = append(batch["items"].([]map[string]any), map[string]any{"plaintext": "Ah-nold", "aad": "not supported"})
+		w := do(h, http.MethodPost, sessionPath+id+"/encrypt:batch", batch)
+		var resp EncryptBatchResponse
+		decode(t, w, &resp)
+		if w.Code != http.StatusOK || len(resp.Results) != 3 || resp.Results[2].Error == nil {
+			t.Fatalf("expected the last of 3 items to fail, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var info SessionInfoResponse
+		decode(t, do(h, http.MethodGet, sessionPath+id, nil), &info)
+		if info.UsageCount != 2 {
+			t.Errorf("expected usage count of 2, got %d", info.UsageCount)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encodings understood by the API for carrying bytes in JSON strings. Not every
+// encoding is valid for every field; see the allowed lists below.
+const (
+	encodingRaw       = "raw"
+	encodingUTF8      = "utf8"
+	encodingBase64    = "base64"
+	encodingBase64URL = "base64url"
+	encodingHex       = "hex"
+)
+
+var (
+	// keyEncodings are the encodings a session key may be supplied in.
+	keyEncodings = []string{encodingRaw, encodingBase64, encodingBase64URL, encodingHex}
+
+	// plaintextEncodings are the encodings a plaintext may be supplied or
+	// returned in.
+	plaintextEncodings = []string{encodingUTF8, encodingBase64, encodingHex}
+
+	// cipherTextEncodings are the encodings a cipher text may be supplied or
+	// returned in.
+	cipherTextEncodings = []string{encodingBase64, encodingHex}
+)
+
+// normaliseEncoding lower cases the named encoding for field and checks it is
+// one of allowed, returning the default def if it is empty.
+func normaliseEncoding(field, encoding, def string, allowed []string) (string, error) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" {
+		return def, nil
+	}
+	if !slices.Contains(allowed, encoding) {
+		return "", fmt.Errorf("unsupported %s %q; expected one of %s",
+			field, encoding, strings.Join(allowed, ", "))
+	}
+	return encoding, nil
+}
+
+// decodeString decodes s from the named encoding. Raw and utf8 strings are
+// used as is. Base64 input is accepted with or without padding.
+func decodeString(field, s, encoding string) ([]byte, error) {
+	var (
+		decoded []byte
+		err     error
+	)
+	switch encoding {
+	case encodingRaw, encodingUTF8:
+		return []byte(s), nil
+	case encodingBase64:
+		decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+	case encodingBase64URL:
+		decoded, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	case encodingHex:
+		decoded, err = hex.DecodeString(s)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid %s: %w", field, encoding, err)
+	}
+
+	return decoded, nil
+}
+
+// encodeBytes encodes b in the named encoding for inclusion in a response.
+// Bytes which are not valid UTF-8 cannot be returned with the utf8 encoding.
+// Raw bytes cannot be safely carried in JSON so raw falls back to base64.
+func encodeBytes(b []byte, encoding string) (string, error) {
+	switch encoding {
+	case encodingUTF8:
+		if !utf8.Valid(b) {
+			return "", errors.New("plaintext is not valid utf8; request an output_encoding of base64 or hex")
+		}
+		return string(b), nil
+	case encodingBase64URL:
+		return base64.URLEncoding.EncodeToString(b), nil
+	case encodingHex:
+		return hex.EncodeToString(b), nil
+	default:
+		return base64.StdEncoding.EncodeToString(b), nil
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDecodeString(t *testing.T) {
+	// These bytes encode differently in the standard and URL base64 alphabets.
+	b := []byte{0xfb, 0xff, 0xbf, 0x01}
+
+	testCases := []struct {
+		name     string
+		s        string
+		encoding string
+		expected []byte // Nil if invalid.
+	}{
+		{"Raw", "Ah-nold", encodingRaw, []byte("Ah-nold")},
+		{"UTF-8", "Ah-nold", encodingUTF8, []byte("Ah-nold")},
+		{"Base64", "+/+/AQ==", encodingBase64, b},
+		{"Base64 without padding", "+/+/AQ", encodingBase64, b},
+		{"Base64url", "-_-_AQ==", encodingBase64URL, b},
+		{"Base64url without padding", "-_-_AQ", encodingBase64URL, b},
+		{"Hex", "fbffbf01", encodingHex, b},
+		{"Upper case hex", "FBFFBF01", encodingHex, b},
+		{"Base64 in the URL alphabet", "-_-_AQ", encodingBase64, nil},
+		{"Invalid base64", "not base64!", encodingBase64, nil},
+		{"Base64url in the standard alphabet", "+/+/AQ", encodingBase64URL, nil},
+		{"Invalid base64url", "not base64url!", encodingBase64URL, nil},
+		{"Odd length hex", "fbffbf0", encodingHex, nil},
+		{"Invalid hex", "not hex", encodingHex, nil},
+		{"Unsupported encoding", "Ah-nold", "base32", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decoded, err := decodeString("key", tc.s, tc.encoding)
+			if tc.expected == nil {
+				if err == nil {
+					t.Errorf("expected an error, got %x", decoded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(decoded, tc.expected) {
+				t.Errorf("expected %x, got %x", tc.expected, decoded)
+			}
+		})
+	}
+}
+
+func TestSessionRequest_KeyEncoding(t *testing.T) {
+	key := bytes.Repeat([]byte{0xfb}, 32)
+
+	testCases := []struct {
+		name     string
+		key      string
+		encoding string
+		valid    bool
+	}{
+		{"Raw", strings.Repeat("k", 32), "", true},
+		{"Base64", base64.StdEncoding.EncodeToString(key), "base64", true},
+		{"Base64 without padding", base64.RawStdEncoding.EncodeToString(key), "base64", true},
+		{"Base64url", base64.URLEncoding.EncodeToString(key), "base64url", true},
+		{"Hex", hex.EncodeToString(key), "hex", true},
+		{"Encoding name in upper case", hex.EncodeToString(key), "HEX", true},
+		{"Unsupported encoding", hex.EncodeToString(key), "base32", false},
+		{"Invalid base64", strings.Repeat("!", 44), "base64", false},
+		{"Invalid base64url", base64.StdEncoding.EncodeToString(key), "base64url", false},
+		{"Invalid hex", strings.Repeat("z", 64), "hex", false},
+
+		// Key sizes are checked once decoded, so a string of the right length
+		// is rejected if it decodes to the wrong number of bytes.
+		{"Base64 of a short key", base64.StdEncoding.EncodeToString(key[:24]), "base64", false},
+		{"Hex of a short key", hex.EncodeToString(key[:16]), "hex", false},
+		{"Short raw key", strings.Repeat("k", 16), "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := &SessionRequest{Session: &Session{
+				AlgorithmName: "aes256-gcm",
+				Key:           tc.key,
+				KeyEncoding:   tc.encoding,
+			}}
+			err := sr.Bind(nil)
+			if !tc.valid {
+				if err == nil {
+					t.Errorf("expected an error, got key %x", sr.key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(sr.key) != 32 {
+				t.Errorf("expected a 32 byte key, got %d bytes", len(sr.key))
+			}
+		})
+	}
+}
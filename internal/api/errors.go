@@ -23,14 +23,38 @@ func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func ErrNotFound() render.Renderer {
+func ErrNotFound() *ErrResponse {
 	return &ErrResponse{
 		HTTPStatusCode: http.StatusNotFound,
 		StatusText:     http.StatusText(http.StatusNotFound),
 	}
 }
 
-func ErrInvalidRequest(err error) render.Renderer {
+func ErrUnauthorized() *ErrResponse {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusUnauthorized,
+		StatusText:     http.StatusText(http.StatusUnauthorized),
+		ErrorText:      "a valid API key is required",
+	}
+}
+
+func ErrServiceUnavailable(reason string) *ErrResponse {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusServiceUnavailable,
+		StatusText:     http.StatusText(http.StatusServiceUnavailable),
+		ErrorText:      reason,
+	}
+}
+
+func ErrTooManyRequests() *ErrResponse {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusTooManyRequests,
+		StatusText:     http.StatusText(http.StatusTooManyRequests),
+		ErrorText:      "rate limit exceeded, retry after the number of seconds in the Retry-After header",
+	}
+}
+
+func ErrInvalidRequest(err error) *ErrResponse {
 	return &ErrResponse{
 		Err:            err,
 		HTTPStatusCode: 400,
@@ -39,7 +63,7 @@ func ErrInvalidRequest(err error) render.Renderer {
 	}
 }
 
-func (h *Handlers) ErrInternalServer(err error) render.Renderer {
+func (h *Handlers) ErrInternalServer(err error) *ErrResponse {
 	h.logger.Error("internal server error", "err", err)
 	return &ErrResponse{
 		Err:            err,
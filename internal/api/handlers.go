@@ -3,54 +3,121 @@ package api
 import (
 	"atostechtest/internal/encryption"
 	"atostechtest/internal/sessionstore"
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Handlers struct {
 	sessionStore *sessionstore.Store
+	apiKeys      *APIKeys // Nil if authentication is disabled.
 	Router       chi.Router
 	logger       *slog.Logger
+	ready        atomic.Bool // Reported by the readiness probe.
+
+	requestLimiter       *rateLimiter // Nil if not rate limited.
+	sessionCreateLimiter *rateLimiter // Nil if not rate limited.
+	authFailureLimiter   *rateLimiter // Nil if not rate limited.
+	maxBatchSize         int
+}
+
+// Options holds the optional settings of the API handlers. The zero value
+// disables rate limiting and allows batches of DefaultMaxBatchSize items.
+type Options struct {
+	RateLimit              RateLimit // Per client, across all session endpoints.
+	SessionCreateRateLimit RateLimit // Per client, on session creation only.
+	AuthFailureRateLimit   RateLimit // Per IP address, on failed API key authentication.
+	MaxBatchSize           int       // Maximum items in a batch encrypt or decrypt request.
 }
 
-// @title			Richard Merry ATOS Tech Test
-// @description	A simple API for creating symmetric encryption sessions within which plaintext can be encrypted and cipher text decrypted. Sessions have a limited lifetime, currently set to 10 minutes.
-// @contact.name	Richard Merry
-// @host			localhost:8081
-// @BasePath		/api/v1
-func NewHTTPHandlers(sessionStore *sessionstore.Store) *Handlers {
+// NewHTTPHandlers returns the API handlers. Session endpoints require a key
+// from apiKeys, and sessions can only be used by the principal that created
+// them; if apiKeys is nil the principal is the identity of the TLS client
+// certificate, if any. Clients exceeding the rate limits in opts, or making
+// too many failed authentication attempts, are rejected with a 429.
+//
+//	@title						Richard Merry ATOS Tech Test
+//	@description				A simple API for creating symmetric encryption sessions within which plaintext can be encrypted and cipher text decrypted. Sessions have a limited lifetime, capped by a server configured maximum (10 minutes by default), and may also be limited in use.
+//	@contact.name				Richard Merry
+//	@host						localhost:8081
+//	@BasePath					/api/v1
+//
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						X-API-Key
+//	@description				An API key, which may instead be sent as an Authorization: Bearer token.
+func NewHTTPHandlers(sessionStore *sessionstore.Store, apiKeys *APIKeys, opts Options) *Handlers {
 	logger := slog.Default().With("component", "api")
 	mux := chi.NewRouter()
 
 	h := &Handlers{
 		sessionStore: sessionStore,
+		apiKeys:      apiKeys,
 		Router:       mux,
 		logger:       logger,
 	}
+	h.SetReady(true)
+	h.maxBatchSize = opts.MaxBatchSize
+	if h.maxBatchSize <= 0 {
+		h.maxBatchSize = DefaultMaxBatchSize
+	}
+	if opts.RateLimit.PerSecond > 0 {
+		h.requestLimiter = newRateLimiter("requests", opts.RateLimit)
+	}
+	if opts.SessionCreateRateLimit.PerSecond > 0 {
+		h.sessionCreateLimiter = newRateLimiter("session_create", opts.SessionCreateRateLimit)
+	}
+	if opts.AuthFailureRateLimit.PerSecond > 0 && apiKeys != nil {
+		h.authFailureLimiter = newRateLimiter("auth_failure", opts.AuthFailureRateLimit)
+	}
 
 	// Attach middleware.
 	mux.Use(middleware.RequestID)
 	mux.Use(NewSlogRequestLogger(logger.Handler()))
+	mux.Use(tracing)
+	mux.Use(metrics)
+
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Get("/healthz", h.healthz)
+	mux.Get("/readyz", h.readyz)
 
 	mux.Route("/api", func(r chi.Router) {
 		r.Route("/v1", func(r chi.Router) {
 
 			r.Route("/session", func(r chi.Router) {
-				r.Post("/", h.createSession)
+				r.Use(h.authenticate) // Put the principal on the request context.
+				r.Use(h.rateLimit(h.requestLimiter))
+
+				r.With(h.rateLimit(h.sessionCreateLimiter)).Post("/", h.createSession)
 
 				r.Route("/{sessionID}", func(r chi.Router) {
 					r.Use(h.sessionCtx) // Put the session on the request context.
 
+					r.Get("/", h.getSession)
+					r.Delete("/", h.deleteSession)
+					r.Post("/refresh", h.refreshSession)
+					r.Post("/keys", h.createKey)
+
 					r.Route("/encrypt", func(r chi.Router) {
 						r.Post("/", h.createEncrypt)
+						r.Post("/stream", h.createEncryptStream)
 					})
 					r.Route("/decrypt", func(r chi.Router) {
 						r.Post("/", h.createDecrypt)
+						r.Post("/stream", h.createDecryptStream)
 					})
+					r.Post("/encrypt:batch", h.createEncryptBatch)
+					r.Post("/decrypt:batch", h.createDecryptBatch)
+					r.Post("/reencrypt", h.createReencrypt)
 				})
 			})
 
@@ -64,11 +131,19 @@ func NewHTTPHandlers(sessionStore *sessionstore.Store) *Handlers {
 	return h
 }
 
-// Decrypts a base64 encoded cipher text input and return an unencoded plaintext.
+// Decrypts an encoded (base64 by default) cipher text input and returns the
+// plaintext in the requested output encoding (utf8 by default).
 //
 //	@Summary		Decrypt cipher text.
 //	@Description	Decrypt cipher text in the context of a specific encryption session.
 //	@Description	The cipher will be decrypted using the specific algorithm and key associated with the session.
+//	@Description	For authenticated (AEAD) algorithms a cipher text which fails authentication, including when the
+//	@Description	supplied additional authenticated data does not match, is rejected with a 400.
+//	@Description	Cipher texts carry a header naming the algorithm and key they were encrypted with, so any key in the
+//	@Description	session's keyring is used as needed; one encrypted under another session's algorithm or key, or
+//	@Description	under a different context, is rejected with a 400.
+//	@Description	The cipher text is read as base64 unless ciphertext_encoding says otherwise. The plaintext is returned
+//	@Description	as utf8 unless output_encoding is base64 or hex; binary plaintexts must request one of these.
 //	@Tags			encryption, session
 //	@Accept			json
 //	@Produce		json
@@ -76,39 +151,98 @@ func NewHTTPHandlers(sessionStore *sessionstore.Store) *Handlers {
 //	@Param			request		body		DecryptRequest	true	"Request body"
 //	@Success		200			{object}	DecryptResponse
 //	@Failure		400			{object}	ErrResponse
+//	@Failure		401			{object}	ErrResponse
 //	@Failure		404			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
 //	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
 //	@Router			/session/{session_id}/decrypt   [post]
 func (h *Handlers) createDecrypt(w http.ResponseWriter, r *http.Request) {
 	data := &DecryptRequest{}
-	if err := render.Bind(r, data); err != nil {
+	if err := bind(r, data); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
 	s := r.Context().Value("session").(*sessionstore.Session)
-	plaintext, err := encryption.Decrypt(
-		algorithmFromText(s.AlgorithmName),
-		[]byte(s.Key),
-		data.Ciphertext,
-	)
-	if err != nil {
-		// FIXME: error is wrong
-		h.logger.Info("error here")
-		render.Render(w, r, h.ErrInternalServer(err))
+	reserved := sessionstore.Usage{Operations: 1, Bytes: int64(len(data.cipherText))}
+	if !h.reserveUsage(w, r, s, reserved) {
 		return
 	}
 
+	_, span := tracer.Start(r.Context(), "encryption.Decrypt",
+		trace.WithAttributes(attribute.String("session.algorithm", s.AlgorithmName)))
+	encoded, errResp := h.decrypt(s, data)
+	span.End()
+	if errResp != nil {
+		h.recordUsage(r.Context(), s, "decrypt", reserved, sessionstore.Usage{})
+		render.Render(w, r, errResp)
+		return
+	}
+
+	h.recordUsage(r.Context(), s, "decrypt", reserved, reserved)
+
 	render.Status(r, http.StatusOK)
-	h.logger.Info("finally here")
-	render.Render(w, r, &DecryptResponse{Plaintext: plaintext})
+	render.Render(w, r, &DecryptResponse{Plaintext: encoded})
+}
+
+// decrypt decrypts a bound request under a session and returns the plaintext
+// in the requested encoding, or the error response to send.
+func (h *Handlers) decrypt(s *sessionstore.Session, data *DecryptRequest) (string, *ErrResponse) {
+	plaintext, errResp := h.open(s, data.Context, data.cipherText, []byte(data.AAD))
+	if errResp != nil {
+		return "", errResp
+	}
+
+	encoded, err := encodeBytes(plaintext, data.OutputEncoding)
+	if err != nil {
+		return "", ErrInvalidRequest(err)
+	}
+	return encoded, nil
+}
+
+// open decrypts a cipher text under a session, and context label if any, with
+// whichever of its keys the cipher text was encrypted with, returning the
+// plaintext or the error response to send.
+func (h *Handlers) open(s *sessionstore.Session, label string, cipherText, aad []byte) ([]byte, *ErrResponse) {
+	key, keyID, err := s.DecryptionKey(cipherText, label)
+	if err != nil {
+		return nil, h.ErrInternalServer(err)
+	}
+
+	plaintext, err := encryption.Decrypt(
+		encryption.Algorithm(s.AlgorithmName),
+		[]byte(key),
+		keyID,
+		cipherText,
+		aad,
+	)
+	if errors.Is(err, encryption.ErrAuthenticationFailed) ||
+		errors.Is(err, encryption.ErrAADNotSupported) ||
+		errors.Is(err, encryption.ErrInvalidCipherTextBlockSize) ||
+		errors.Is(err, encryption.ErrInvalidEnvelope) ||
+		errors.Is(err, encryption.ErrUnsupportedVersion) ||
+		errors.Is(err, encryption.ErrAlgorithmMismatch) ||
+		errors.Is(err, encryption.ErrKeyMismatch) {
+		return nil, ErrInvalidRequest(err)
+	}
+	if err != nil {
+		return nil, h.ErrInternalServer(err)
+	}
+	return plaintext, nil
 }
 
-// Encrypt a non-encoded plaintext input and returns a base64 encoded cipher text.
+// Encrypt a plaintext input, given in the requested encoding (utf8 by default),
+// and returns an encoded (base64 by default) cipher text.
 //
 //	@Summary		Encrypt plaintext.
 //	@Description	Encrypt plaintext in the context of a specific encryption session.
 //	@Description	The plaintext will be encrypted using the specific algorithm and key associated with the session.
+//	@Description	Additional authenticated data may be supplied for authenticated (AEAD) algorithms only.
+//	@Description	A context label may be supplied to encrypt under a sub-key of the session key derived for that context
+//	@Description	with HKDF; the cipher text can then only be decrypted under the same context.
+//	@Description	Binary plaintexts may be supplied base64 or hex encoded (see plaintext_encoding). The cipher text is
+//	@Description	returned base64 encoded unless output_encoding is hex.
 //	@Tags			encryption, session
 //	@Accept			json
 //	@Produce		json
@@ -116,58 +250,267 @@ func (h *Handlers) createDecrypt(w http.ResponseWriter, r *http.Request) {
 //	@Param			request		body		EncryptRequest	true	"Request body"
 //	@Success		200			{object}	EncryptResponse
 //	@Failure		400			{object}	ErrResponse
+//	@Failure		401			{object}	ErrResponse
 //	@Failure		404			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
 //	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
 //	@Router			/session/{session_id}/encrypt   [post]
 func (h *Handlers) createEncrypt(w http.ResponseWriter, r *http.Request) {
 	data := &EncryptRequest{}
-	if err := render.Bind(r, data); err != nil {
+	if err := bind(r, data); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
 	s := r.Context().Value("session").(*sessionstore.Session)
-	cipherText, err := encryption.Encrypt(
-		algorithmFromText(s.AlgorithmName),
-		[]byte(s.Key),
-		data.Plaintext,
-	)
-	if err != nil {
-		render.Render(w, r, h.ErrInternalServer(err))
+	reserved := sessionstore.Usage{Operations: 1, Bytes: int64(len(data.plaintext))}
+	if !h.reserveUsage(w, r, s, reserved) {
+		return
+	}
+
+	_, span := tracer.Start(r.Context(), "encryption.Encrypt",
+		trace.WithAttributes(attribute.String("session.algorithm", s.AlgorithmName)))
+	encoded, errResp := h.encrypt(s, data)
+	span.End()
+	if errResp != nil {
+		h.recordUsage(r.Context(), s, "encrypt", reserved, sessionstore.Usage{})
+		render.Render(w, r, errResp)
 		return
 	}
 
+	h.recordUsage(r.Context(), s, "encrypt", reserved, reserved)
+
 	render.Status(r, http.StatusOK)
-	render.Render(w, r, EncryptResponse{CipherText: cipherText})
+	render.Render(w, r, EncryptResponse{CipherText: encoded})
+}
+
+// encrypt encrypts a bound request under a session and returns the cipher
+// text in the requested encoding, or the error response to send.
+func (h *Handlers) encrypt(s *sessionstore.Session, data *EncryptRequest) (string, *ErrResponse) {
+	cipherText, errResp := h.seal(s, data.Context, data.plaintext, []byte(data.AAD))
+	if errResp != nil {
+		return "", errResp
+	}
+
+	encoded, err := encodeBytes(cipherText, data.OutputEncoding)
+	if err != nil {
+		return "", h.ErrInternalServer(err)
+	}
+	return encoded, nil
+}
+
+// seal encrypts a plaintext under the primary key of a session, or its sub-key
+// for the context label if any, returning the cipher text or the error
+// response to send.
+func (h *Handlers) seal(s *sessionstore.Session, label string, plaintext, aad []byte) ([]byte, *ErrResponse) {
+	key, keyID, err := s.EncryptionKey(label)
+	if err != nil {
+		return nil, h.ErrInternalServer(err)
+	}
+
+	cipherText, err := encryption.Encrypt(
+		encryption.Algorithm(s.AlgorithmName),
+		[]byte(key),
+		keyID,
+		plaintext,
+		aad,
+	)
+	if errors.Is(err, encryption.ErrAADNotSupported) {
+		return nil, ErrInvalidRequest(err)
+	}
+	if err != nil {
+		return nil, h.ErrInternalServer(err)
+	}
+	return cipherText, nil
 }
 
 // Creates an encryption session given an algorithm type and key.
 //
 //	@Summary		Create encryption session.
 //	@Description	Create an encryption session associating a session with a specific algorithm and key.
+//	@Description	Keys may be supplied raw or encoded as base64, base64url or hex (see key_encoding); the key size is
+//	@Description	validated after decoding. If no key is supplied the server generates one of the correct size for the
+//	@Description	algorithm. The generated key is returned, once, in the requested key_encoding (base64 by default)
+//	@Description	unless return_key is false (an encrypt-only session).
+//	@Description	Alternatively a passphrase may be supplied, from which the key is derived with argon2id (the default),
+//	@Description	scrypt or pbkdf2 as configured by kdf. The salt and parameters used are returned so that the same key
+//	@Description	can be derived again; the derived key itself is not returned.
+//	@Description	The session lifetime may be shortened with ttl_seconds, and its use limited with max_operations and
+//	@Description	max_bytes; requests which would exceed a limit are rejected with a 400, and once a limit is reached
+//	@Description	the session expires.
 //	@Tags			encryption, session
 //	@Accept			json
 //	@Produce		json
 //	@Param			request	body		SessionRequest	true	"Request body"
 //	@Success		200		{object}	SessionResponse
 //	@Failure		400		{object}	ErrResponse
+//	@Failure		401		{object}	ErrResponse
+//	@Failure		429		{object}	ErrResponse
 //	@Failure		500		{object}	ErrResponse
+//	@Security		ApiKeyAuth
 //	@Router			/session   [post]
 func (h *Handlers) createSession(w http.ResponseWriter, r *http.Request) {
 	data := &SessionRequest{}
-	if err := render.Bind(r, data); err != nil {
+	if err := bind(r, data); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
-	id, err := h.sessionStore.NewSession(data.AlgorithmName, data.Key)
+	var (
+		key = data.key
+		kdf *KDFParams
+		err error
+	)
+	switch {
+	case data.Passphrase != "":
+		var params encryption.KDFParams
+		_, span := tracer.Start(r.Context(), "encryption.DeriveKey",
+			trace.WithAttributes(attribute.String("kdf.name", string(data.kdf.KDF))))
+		key, params, err = encryption.DeriveKey(encryption.Algorithm(data.AlgorithmName), []byte(data.Passphrase), data.kdf)
+		span.End()
+		if errors.Is(err, encryption.ErrInvalidKDFParams) || errors.Is(err, encryption.ErrUnsupportedKDF) {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		kdf = newKDFParams(params)
+	case data.GenerateKey():
+		key, err = encryption.GenerateKey(encryption.Algorithm(data.AlgorithmName))
+	}
+	if err != nil {
+		render.Render(w, r, h.ErrInternalServer(err))
+		return
+	}
+
+	session, err := h.sessionStore.NewSession(r.Context(), principal(r), data.AlgorithmName, string(key), data.Limits())
 	if err != nil {
 		render.Render(w, r, h.ErrInternalServer(err))
 		return
 	}
 
+	resp := &SessionResponse{ID: session.ID, ExpiresAt: session.ExpiresAt, KDF: kdf}
+	if data.ShouldReturnKey() {
+		resp.Key, _ = encodeBytes(key, data.KeyEncoding) // Never utf8 so cannot fail.
+	}
+
 	render.Status(r, http.StatusCreated)
-	render.Render(w, r, &SessionResponse{ID: id})
+	render.Render(w, r, resp)
+}
+
+// Returns the details of an encryption session, excluding its key.
+//
+//	@Summary		Inspect encryption session.
+//	@Description	Returns the algorithm, creation time, expiry time, usage limits and usage of an encryption session.
+//	@Description	The session key is never returned.
+//	@Tags			session
+//	@Produce		json
+//	@Param			session_id	path		string	false	"An encryption session ID"
+//	@Success		200			{object}	SessionInfoResponse
+//	@Failure		401			{object}	ErrResponse
+//	@Failure		404			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
+//	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}   [get]
+func (h *Handlers) getSession(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value("session").(*sessionstore.Session)
+
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newSessionInfoResponse(s))
+}
+
+// Revokes an encryption session immediately.
+//
+//	@Summary		Revoke encryption session.
+//	@Description	Deletes an encryption session before it expires. Any further use of the session returns a 404.
+//	@Tags			session
+//	@Param			session_id	path	string	false	"An encryption session ID"
+//	@Success		204
+//	@Failure		401	{object}	ErrResponse
+//	@Failure		404	{object}	ErrResponse
+//	@Failure		429	{object}	ErrResponse
+//	@Failure		500	{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}   [delete]
+func (h *Handlers) deleteSession(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value("session").(*sessionstore.Session)
+	if err := h.sessionStore.DeleteSession(r.Context(), s.ID); err != nil {
+		render.Render(w, r, h.ErrInternalServer(err))
+		return
+	}
+
+	render.NoContent(w, r)
+}
+
+// Extends the life of an encryption session.
+//
+//	@Summary		Refresh encryption session.
+//	@Description	Resets the expiry of an encryption session so that it expires its ttl_seconds from now (sliding
+//	@Description	expiry). Usage limits are not reset. Expired or exhausted sessions cannot be refreshed.
+//	@Tags			session
+//	@Produce		json
+//	@Param			session_id	path		string	false	"An encryption session ID"
+//	@Success		200			{object}	SessionInfoResponse
+//	@Failure		401			{object}	ErrResponse
+//	@Failure		404			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
+//	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}/refresh   [post]
+func (h *Handlers) refreshSession(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value("session").(*sessionstore.Session)
+	refreshed, err := h.sessionStore.RefreshSession(r.Context(), s.ID)
+	if err == sessionstore.ErrSessionNotFound || err == sessionstore.ErrSessionExpired {
+		// Revoked or expired since the session was put on the context.
+		render.Render(w, r, ErrNotFound())
+		return
+	}
+	if err != nil {
+		render.Render(w, r, h.ErrInternalServer(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newSessionInfoResponse(refreshed))
+}
+
+// reserveUsage reserves usage against the limits of a session before the
+// operations are carried out (see sessionstore.Store.ReserveUsage). If the
+// session has too little usage left an error response is sent and false
+// returned.
+func (h *Handlers) reserveUsage(w http.ResponseWriter, r *http.Request, s *sessionstore.Session, u sessionstore.Usage) bool {
+	err := h.sessionStore.ReserveUsage(r.Context(), s.ID, u)
+	if err != nil {
+		render.Render(w, r, h.usageErrResponse(err))
+		return false
+	}
+	return true
+}
+
+// usageErrResponse returns the error response for a failed usage reservation.
+func (h *Handlers) usageErrResponse(err error) *ErrResponse {
+	switch err {
+	case sessionstore.ErrUsageLimitExceeded:
+		return ErrInvalidRequest(err)
+	case sessionstore.ErrSessionNotFound, sessionstore.ErrSessionExpired:
+		// Revoked, expired or exhausted since the session was put on the
+		// context.
+		return ErrNotFound()
+	default:
+		return h.ErrInternalServer(err)
+	}
+}
+
+// recordUsage settles the usage reserved for a request with that actually
+// used by its successful operations, and counts those in the operation
+// metrics. Failures are logged but not returned to the client, whose
+// operations have already been carried out.
+func (h *Handlers) recordUsage(ctx context.Context, s *sessionstore.Session, operation string, reserved, used sessionstore.Usage) {
+	cryptoOperations.WithLabelValues(operation, s.AlgorithmName).Add(float64(used.Operations))
+	cryptoBytes.WithLabelValues(operation, s.AlgorithmName).Add(float64(used.Bytes))
+	if err := h.sessionStore.SettleUsage(ctx, s.ID, reserved, used); err != nil {
+		h.logger.Warn("recording session usage", "id", s.ID, "err", err)
+	}
 }
 
 // Retrieves the list of supported symmetric encryption algorithms.
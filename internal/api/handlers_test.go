@@ -0,0 +1,118 @@
+package api
+
+import (
+	"atostechtest/internal/datastore"
+	"atostechtest/internal/sessionstore"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const sessionPath = "/api/v1/session/"
+
+var testMasterKey = []byte("0123456789abcdefghijklmopqrstuvw")
+
+func newTestHandlers(t *testing.T, apiKeys *APIKeys, opts Options) *Handlers {
+	t.Helper()
+	db := datastore.NewInMemory(time.Minute)
+	t.Cleanup(db.Close)
+	return newTestHandlersWithDB(t, db, apiKeys, opts)
+}
+
+// newTestHandlersWithDB returns handlers whose sessions are held in db.
+func newTestHandlersWithDB(t *testing.T, db datastore.DB, apiKeys *APIKeys, opts Options) *Handlers {
+	t.Helper()
+	store, err := sessionstore.New(db, time.Hour, testMasterKey)
+	if err != nil {
+		t.Fatalf("unexpected error creating store: %v", err)
+	}
+	return NewHTTPHandlers(store, apiKeys, opts)
+}
+
+// do sends a request to the handlers and returns the response. A body which
+// is not already a byte slice is sent as JSON. header holds pairs of header
+// names and values.
+func do(h *Handlers, method, path string, body any, header ...string) *httptest.ResponseRecorder {
+	return serve(h, newRequest(method, path, body, header...))
+}
+
+// newRequest returns a request as sent by do.
+func newRequest(method, path string, body any, header ...string) *http.Request {
+	var r io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r, contentType = bytes.NewReader(b), contentTypeOctetStream
+	default:
+		encoded, _ := json.Marshal(b)
+		r = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set("Content-Type", contentType)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return req
+}
+
+// serve sends a request to the handlers and returns the response.
+func serve(h *Handlers, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	return w
+}
+
+// decode unmarshals a JSON response body into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("unexpected error decoding response %q: %v", w.Body.String(), err)
+	}
+}
+
+// newSession creates a session from the given request body and returns its
+// ID.
+func newSession(t *testing.T, h *Handlers, body map[string]any, header ...string) string {
+	t.Helper()
+	w := do(h, http.MethodPost, sessionPath, body, header...)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected session to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp SessionResponse
+	decode(t, w, &resp)
+	return resp.ID
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	h := newTestHandlers(t, nil, Options{})
+	id := newSession(t, h, map[string]any{"algorithm": "aes256-gcm"})
+
+	w := do(h, http.MethodPost, sessionPath+id+"/encrypt", map[string]any{"plaintext": "Ah-nold", "aad": "header"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var encrypted EncryptResponse
+	decode(t, w, &encrypted)
+
+	t.Run("Round trip", func(t *testing.T) {
+		w := do(h, http.MethodPost, sessionPath+id+"/decrypt", map[string]any{"ciphertext": encrypted.CipherText, "aad": "header"})
+		var decrypted DecryptResponse
+		decode(t, w, &decrypted)
+		if w.Code != http.StatusOK || decrypted.Plaintext != "Ah-nold" {
+			t.Errorf("expected plaintext %q, got %d: %s", "Ah-nold", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Wrong AAD", func(t *testing.T) {
+		w := do(h, http.MethodPost, sessionPath+id+"/decrypt", map[string]any{"ciphertext": encrypted.CipherText, "aad": "footer"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// HealthResponse reports the health of the service.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+func (hr *HealthResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// SetReady marks the service as ready, or not, to receive traffic. It is
+// set not ready when shutdown begins so that load balancers stop sending new
+// requests before the server stops accepting them.
+func (h *Handlers) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// healthz is the liveness probe; it succeeds whenever the process is able to
+// serve requests at all.
+func (h *Handlers) healthz(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &HealthResponse{Status: "ok"})
+}
+
+// readyz is the readiness probe. It fails once shutdown has begun, or if the
+// datastore does not answer a round trip or its session clean up routine has
+// stopped.
+func (h *Handlers) readyz(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() {
+		render.Render(w, r, ErrServiceUnavailable("shutting down"))
+		return
+	}
+	if err := h.sessionStore.Ping(r.Context()); err != nil {
+		render.Render(w, r, ErrServiceUnavailable("datastore unavailable"))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &HealthResponse{Status: "ready"})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"atostechtest/internal/datastore"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// unreachableDB is a datastore whose round trips always fail.
+type unreachableDB struct {
+	datastore.DB
+}
+
+func (unreachableDB) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestHealth(t *testing.T) {
+	h := newTestHandlers(t, nil, Options{})
+
+	if w := do(h, http.MethodGet, "/healthz", nil); w.Code != http.StatusOK {
+		t.Errorf("expected healthz to return 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(h, http.MethodGet, "/readyz", nil); w.Code != http.StatusOK {
+		t.Errorf("expected readyz to return 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("Shutting down", func(t *testing.T) {
+		h.SetReady(false)
+		t.Cleanup(func() { h.SetReady(true) })
+
+		if w := do(h, http.MethodGet, "/readyz", nil); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected readyz to return 503, got %d: %s", w.Code, w.Body.String())
+		}
+		// The process is still alive whilst it drains.
+		if w := do(h, http.MethodGet, "/healthz", nil); w.Code != http.StatusOK {
+			t.Errorf("expected healthz to return 200, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Datastore unavailable", func(t *testing.T) {
+		db := datastore.NewInMemory(time.Minute)
+		t.Cleanup(db.Close)
+		h := newTestHandlersWithDB(t, unreachableDB{db}, nil, Options{})
+
+		if w := do(h, http.MethodGet, "/readyz", nil); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected readyz to return 503, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := do(h, http.MethodGet, "/healthz", nil); w.Code != http.StatusOK {
+			t.Errorf("expected healthz to return 200, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
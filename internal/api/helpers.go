@@ -1,19 +1,34 @@
 package api
 
-import "atostechtest/internal/encryption"
+import (
+	"atostechtest/internal/encryption"
+	"strconv"
+	"strings"
+)
 
-// algorithmFromText takes a text input (as will come via the API) algorithm
-// name and turns it into a hard coded Algorithm type as understood by the
-// encryption package.
-func algorithmFromText(input string) encryption.Algorithm {
-	switch input {
-	case "aes128":
-		return encryption.AES128
-	case "aes192":
-		return encryption.AES192
-	case "aes256":
-		return encryption.AES256
-	default:
-		return encryption.DES
+// canonicalAlgorithmName matches a user supplied algorithm name against the
+// algorithms registered with the encryption package, ignoring case and hyphens
+// (so "AES-128" and "aes128gcm" are both accepted). The canonical name is
+// returned along with a boolean indicating whether a match was found.
+func canonicalAlgorithmName(input string) (string, bool) {
+	normalise := func(s string) string {
+		return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(s)), "-", "")
 	}
+
+	input = normalise(input)
+	for _, algo := range encryption.Algorithms() {
+		if normalise(algo) == input {
+			return algo, true
+		}
+	}
+	return "", false
+}
+
+// joinInts formats a list of integers as a single string separated by sep.
+func joinInts(ints []int, sep string) string {
+	strs := make([]string, len(ints))
+	for i, n := range ints {
+		strs[i] = strconv.Itoa(n)
+	}
+	return strings.Join(strs, sep)
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"atostechtest/internal/encryption"
+	"atostechtest/internal/sessionstore"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/render"
+)
+
+// Adds a key to, or promotes a key within, the keyring of an encryption
+// session.
+//
+//	@Summary		Add or promote a session key.
+//	@Description	A session holds a keyring: a primary key, used to encrypt, and up to 15 further keys which can only
+//	@Description	decrypt. Every cipher text records the ID of the key it was encrypted with, so cipher texts encrypted
+//	@Description	under any key held can still be decrypted after a rotation.
+//	@Description	Supply key to add a key (or leave both key and key_id out to have the server generate one, which is
+//	@Description	returned once unless return_key is false), with promote set to make it the primary key. Supply key_id
+//	@Description	instead to promote a key the session already holds. Adding a key which is already held is not an
+//	@Description	error.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		string		false	"An encryption session ID"
+//	@Param			request		body		KeyRequest	true	"Request body"
+//	@Success		200			{object}	KeyResponse
+//	@Failure		400			{object}	ErrResponse
+//	@Failure		401			{object}	ErrResponse
+//	@Failure		404			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
+//	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}/keys   [post]
+func (h *Handlers) createKey(w http.ResponseWriter, r *http.Request) {
+	data := &KeyRequest{}
+	if err := bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	s := r.Context().Value("session").(*sessionstore.Session)
+
+	var (
+		updated *sessionstore.Session
+		keyID   string
+		key     = data.key
+		err     error
+	)
+	switch {
+	case data.KeyID != "":
+		updated, err = h.sessionStore.PromoteKey(r.Context(), s.ID, data.KeyID)
+		if err == nil {
+			keyID = updated.KeyIDs()[0] // The promoted key, in lower case.
+		}
+	default:
+		if data.GenerateKey() {
+			if key, err = encryption.GenerateKey(encryption.Algorithm(s.AlgorithmName)); err != nil {
+				render.Render(w, r, h.ErrInternalServer(err))
+				return
+			}
+		} else if err := validateKey(s.AlgorithmName, key); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		updated, err = h.sessionStore.AddKey(r.Context(), s.ID, string(key), data.Promote)
+		if err == nil {
+			keyID = updated.KeyIDs()[slices.Index(updated.Keys(), string(key))]
+		}
+	}
+	if errors.Is(err, sessionstore.ErrKeyNotFound) || errors.Is(err, sessionstore.ErrTooManyKeys) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err == sessionstore.ErrSessionNotFound || err == sessionstore.ErrSessionExpired {
+		// Revoked or expired since the session was put on the context.
+		render.Render(w, r, ErrNotFound())
+		return
+	}
+	if err != nil {
+		render.Render(w, r, h.ErrInternalServer(err))
+		return
+	}
+
+	keyIDs := updated.KeyIDs()
+	resp := &KeyResponse{KeyID: keyID, PrimaryKeyID: keyIDs[0], KeyIDs: keyIDs}
+	if data.ShouldReturnKey() {
+		resp.Key, _ = encodeBytes(key, data.KeyEncoding) // Never utf8 so cannot fail.
+	}
+
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, resp)
+}
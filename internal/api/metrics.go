@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "atostechtest",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route, method and response status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "atostechtest",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// Limits are one of requests, session_create or auth_failure.
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "atostechtest",
+		Subsystem: "http",
+		Name:      "rate_limited_total",
+		Help:      "Number of requests rejected by a rate limit.",
+	}, []string{"limit"})
+
+	// Operations are one of encrypt or decrypt.
+	cryptoOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "atostechtest",
+		Subsystem: "encryption",
+		Name:      "operations_total",
+		Help:      "Number of successful encrypt and decrypt operations by algorithm.",
+	}, []string{"operation", "algorithm"})
+
+	cryptoBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "atostechtest",
+		Subsystem: "encryption",
+		Name:      "bytes_processed_total",
+		Help:      "Number of input bytes encrypted and decrypted by algorithm.",
+	}, []string{"operation", "algorithm"})
+)
+
+// metrics is middleware which counts and times requests. Requests are labelled
+// with the pattern of the route they matched, rather than their path, so that
+// session IDs do not create unbounded numbers of series.
+func metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			route := chi.RouteContext(r.Context()).RoutePattern()
+			if route == "" {
+				route = "unmatched"
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK // Nothing was written.
+			}
+			httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+			httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	sessionstore "atostechtest/internal/sessionstore"
+	"atostechtest/internal/tlsconfig"
 	"context"
 	"fmt"
 	"log/slog"
@@ -14,22 +15,29 @@ import (
 )
 
 // This middleware reads the session id off requests to the
-// /sessions/{session_id}/... endpoints and checks that the session exists. If
-// it does exist the session is added to the context for easy access down steam
-// otherwise we fail fast with a 404.
+// /sessions/{session_id}/... endpoints and checks that the session exists and
+// is owned by the authenticated principal. If it does the session is added to
+// the context for easy access down steam otherwise we fail fast with a 404, so
+// that the existence of other principals' sessions is not revealed.
 func (h *Handlers) sessionCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sessionID := chi.URLParam(r, "sessionID")
-		session, err := h.sessionStore.GetSession(sessionID)
+		session, err := h.sessionStore.GetSession(r.Context(), sessionID)
 		if err != nil {
-			if err == sessionstore.ErrDatabaseError {
-				render.Render(w, r, h.ErrInternalServer(err))
-			} else if err == sessionstore.ErrSessionNotFound ||
+			if err == sessionstore.ErrSessionNotFound ||
 				err == sessionstore.ErrSessionExpired {
 				render.Render(w, r, ErrNotFound())
+			} else {
+				// Database errors, and session keys which cannot be
+				// unwrapped with the configured master keys.
+				render.Render(w, r, h.ErrInternalServer(err))
 			}
 			return
 		}
+		if session.Owner != principal(r) {
+			render.Render(w, r, ErrNotFound())
+			return
+		}
 
 		ctx := context.WithValue(r.Context(), "session", session)
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -66,6 +74,9 @@ func (l *SlogWrapper) NewLogEntry(r *http.Request) middleware.LogEntry {
 	if r.TLS != nil {
 		scheme = "https"
 	}
+	if id := tlsconfig.ClientIdentity(r.TLS); id != "" {
+		logFields = append(logFields, slog.String("client_identity", id))
+	}
 
 	handler := l.Logger.WithAttrs(append(logFields,
 		slog.String("scheme", scheme),
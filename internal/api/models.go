@@ -2,9 +2,14 @@ package api
 
 import (
 	"atostechtest/internal/encryption"
+	"atostechtest/internal/sessionstore"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 )
 
 // AlgorithmsResponse is the 200 response for calls to the algorithms endpoint.
@@ -22,14 +27,58 @@ func (a *AlgorithmsResponse) Render(w http.ResponseWriter, r *http.Request) erro
 //
 // @Description Used for encrypting plaintext under a given session context.
 type EncryptRequest struct {
-	// The plaintext to encrypt.
+	// The plaintext to encrypt, encoded as described by plaintext_encoding.
 	Plaintext string `json:"plaintext"`
+	// The encoding of plaintext: one of utf8 (the default), base64 or hex.
+	// Use base64 or hex for binary data.
+	PlaintextEncoding string `json:"plaintext_encoding,omitempty"`
+	// The encoding of the returned cipher text: one of base64 (the default)
+	// or hex.
+	OutputEncoding string `json:"output_encoding,omitempty"`
+	// Optional additional authenticated data (AEAD algorithms only). It is
+	// not encrypted but must be supplied again, unchanged, to decrypt.
+	AAD string `json:"aad,omitempty"`
+	// Optional context label. The plaintext is encrypted under a sub-key of
+	// the session key derived for this context, and the same context must be
+	// supplied to decrypt.
+	Context string `json:"context,omitempty"`
+
+	plaintext []byte // The decoded plaintext, populated by Bind.
 }
 
 func (er *EncryptRequest) Bind(r *http.Request) error {
 	if strings.TrimSpace(er.Plaintext) == "" {
 		return errors.New("body is required.")
 	}
+
+	var err error
+	er.PlaintextEncoding, err = normaliseEncoding("plaintext_encoding",
+		er.PlaintextEncoding, encodingUTF8, plaintextEncodings)
+	if err != nil {
+		return err
+	}
+	er.OutputEncoding, err = normaliseEncoding("output_encoding",
+		er.OutputEncoding, encodingBase64, cipherTextEncodings)
+	if err != nil {
+		return err
+	}
+	if err := checkContext("context", er.Context); err != nil {
+		return err
+	}
+
+	er.plaintext, err = decodeString("plaintext", er.Plaintext, er.PlaintextEncoding)
+	return err
+}
+
+// maxContextSize is the maximum length in bytes of a context label.
+const maxContextSize = 255
+
+// checkContext returns an error if the context label given in field is too
+// long.
+func checkContext(field, label string) error {
+	if len(label) > maxContextSize {
+		return fmt.Errorf("%s must be at most %d bytes", field, maxContextSize)
+	}
 	return nil
 }
 
@@ -37,7 +86,13 @@ func (er *EncryptRequest) Bind(r *http.Request) error {
 //
 // @Description Used for decrypted cipher text under a given session context.
 type DecryptRequest struct {
-	Ciphertext string `json:"ciphertext"` // The cipher text to decrypt.
+	Ciphertext         string `json:"ciphertext"`                    // The cipher text to decrypt.
+	CiphertextEncoding string `json:"ciphertext_encoding,omitempty"` // The encoding of ciphertext: one of base64 (the default) or hex.
+	OutputEncoding     string `json:"output_encoding,omitempty"`     // The encoding of the returned plaintext: one of utf8 (the default), base64 or hex.
+	AAD                string `json:"aad,omitempty"`                 // The additional authenticated data given at encryption time, if any.
+	Context            string `json:"context,omitempty"`             // The context label given at encryption time, if any.
+
+	cipherText []byte // The decoded cipher text, populated by Bind.
 }
 
 func (er *DecryptRequest) Bind(r *http.Request) error {
@@ -45,13 +100,76 @@ func (er *DecryptRequest) Bind(r *http.Request) error {
 		return errors.New("body is required.")
 	}
 
-	return nil
+	var err error
+	er.CiphertextEncoding, err = normaliseEncoding("ciphertext_encoding",
+		er.CiphertextEncoding, encodingBase64, cipherTextEncodings)
+	if err != nil {
+		return err
+	}
+	er.OutputEncoding, err = normaliseEncoding("output_encoding",
+		er.OutputEncoding, encodingUTF8, plaintextEncodings)
+	if err != nil {
+		return err
+	}
+	if err := checkContext("context", er.Context); err != nil {
+		return err
+	}
+
+	er.cipherText, err = decodeString("ciphertext", er.Ciphertext, er.CiphertextEncoding)
+	return err
+}
+
+// ReencryptRequest is the body to the re-encrypt endpoint.
+//
+// @Description Used for moving a cipher text from one session to another
+// @Description without the plaintext leaving the service.
+type ReencryptRequest struct {
+	Ciphertext         string `json:"ciphertext"`                    // The cipher text to re-encrypt, under the source session.
+	CiphertextEncoding string `json:"ciphertext_encoding,omitempty"` // The encoding of ciphertext: one of base64 (the default) or hex.
+	AAD                string `json:"aad,omitempty"`                 // The additional authenticated data given at encryption time, if any.
+	Context            string `json:"context,omitempty"`             // The context label given at encryption time, if any.
+	TargetSessionID    string `json:"target_session_id"`             // The ID of the session to re-encrypt under.
+	TargetAAD          string `json:"target_aad,omitempty"`          // Additional authenticated data for the new cipher text (AEAD algorithms only), if any.
+	TargetContext      string `json:"target_context,omitempty"`      // The context label to encrypt the new cipher text under, if any.
+	OutputEncoding     string `json:"output_encoding,omitempty"`     // The encoding of the returned cipher text: one of base64 (the default) or hex.
+
+	cipherText []byte // The decoded cipher text, populated by Bind.
+}
+
+func (rr *ReencryptRequest) Bind(r *http.Request) error {
+	if strings.TrimSpace(rr.Ciphertext) == "" {
+		return errors.New("ciphertext is required.")
+	}
+	if strings.TrimSpace(rr.TargetSessionID) == "" {
+		return errors.New("target_session_id is required.")
+	}
+
+	var err error
+	rr.CiphertextEncoding, err = normaliseEncoding("ciphertext_encoding",
+		rr.CiphertextEncoding, encodingBase64, cipherTextEncodings)
+	if err != nil {
+		return err
+	}
+	rr.OutputEncoding, err = normaliseEncoding("output_encoding",
+		rr.OutputEncoding, encodingBase64, cipherTextEncodings)
+	if err != nil {
+		return err
+	}
+	if err := checkContext("context", rr.Context); err != nil {
+		return err
+	}
+	if err := checkContext("target_context", rr.TargetContext); err != nil {
+		return err
+	}
+
+	rr.cipherText, err = decodeString("ciphertext", rr.Ciphertext, rr.CiphertextEncoding)
+	return err
 }
 
 // EncryptResponse is the 200 response for calls to the encrypt endpoint.
 //
-// @Description Contains successfully encrypted message
-// @Description base64 encoded as cipher text.
+// @Description Contains successfully encrypted message encoded
+// @Description (base64 by default) as cipher text.
 type EncryptResponse struct {
 	CipherText string `json:"cipher_text"`
 }
@@ -62,7 +180,8 @@ func (er EncryptResponse) Render(w http.ResponseWriter, r *http.Request) error {
 
 // DecryptResponse is the 200 response for calls to the decrypt endpoint.
 //
-// @Description Contains successfully decrypted message as plaintext.
+// @Description Contains successfully decrypted message as plaintext,
+// @Description encoded as requested by output_encoding.
 type DecryptResponse struct {
 	Plaintext string `json:"plaintext"`
 }
@@ -71,11 +190,82 @@ func (er DecryptResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// EncryptBatchRequest is the body to the batch encrypt endpoint.
+//
+// @Description Used for encrypting many plaintexts under a given session context in one request.
+type EncryptBatchRequest struct {
+	Items []EncryptRequest `json:"items"` // The plaintexts to encrypt, each as for the encrypt endpoint.
+}
+
+func (br *EncryptBatchRequest) Bind(r *http.Request) error {
+	if len(br.Items) == 0 {
+		return errors.New("items is required.")
+	}
+	return nil // Items are bound individually so that errors are per item.
+}
+
+// EncryptBatchResponse is the 200 response for calls to the batch encrypt
+// endpoint.
+//
+// @Description Contains a result for every item in the request, in the same order.
+type EncryptBatchResponse struct {
+	Results []EncryptBatchResult `json:"results"`
+}
+
+func (br *EncryptBatchResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// EncryptBatchResult is the result of encrypting one item of a batch: either
+// its cipher text or, if it could not be encrypted, the error.
+type EncryptBatchResult struct {
+	CipherText string       `json:"cipher_text,omitempty"`
+	Error      *ErrResponse `json:"error,omitempty"`
+}
+
+// DecryptBatchRequest is the body to the batch decrypt endpoint.
+//
+// @Description Used for decrypting many cipher texts under a given session context in one request.
+type DecryptBatchRequest struct {
+	Items []DecryptRequest `json:"items"` // The cipher texts to decrypt, each as for the decrypt endpoint.
+}
+
+func (br *DecryptBatchRequest) Bind(r *http.Request) error {
+	if len(br.Items) == 0 {
+		return errors.New("items is required.")
+	}
+	return nil // Items are bound individually so that errors are per item.
+}
+
+// DecryptBatchResponse is the 200 response for calls to the batch decrypt
+// endpoint.
+//
+// @Description Contains a result for every item in the request, in the same order.
+type DecryptBatchResponse struct {
+	Results []DecryptBatchResult `json:"results"`
+}
+
+func (br *DecryptBatchResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// DecryptBatchResult is the result of decrypting one item of a batch: either
+// its plaintext or, if it could not be decrypted, the error.
+type DecryptBatchResult struct {
+	Plaintext string       `json:"plaintext,omitempty"`
+	Error     *ErrResponse `json:"error,omitempty"`
+}
+
 type Session struct {
 	// The Algorithm to associate with this session.
 	AlgorithmName string `json:"algorithm"`
-	// The key to associate with this session.
-	Key string `json:"key"`
+	// The key to associate with this session. If omitted the server
+	// generates a random key of the correct size for the algorithm.
+	Key string `json:"key,omitempty"`
+	// The encoding of key: one of raw (the default), base64, base64url or
+	// hex. A generated key is returned in this encoding, with raw falling
+	// back to base64.
+	KeyEncoding string `json:"key_encoding,omitempty"`
 }
 
 // SessionRequest is the body to the create session end point.
@@ -83,41 +273,321 @@ type Session struct {
 // @Description Used for configuring and creating a new encryption session.
 type SessionRequest struct {
 	*Session
+	// Whether a server generated key is returned in the response. Defaults
+	// to true; set to false for encrypt-only sessions where the key must
+	// never leave the service. Ignored when a key is supplied.
+	ReturnKey *bool `json:"return_key,omitempty"`
+	// The session lifetime in seconds. Defaults to, and is capped at, the
+	// server's maximum session age.
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+	// The maximum number of encrypt and decrypt operations, after which the
+	// session expires. Requests which would exceed it are rejected. Unlimited
+	// if omitted.
+	MaxOperations int64 `json:"max_operations,omitempty"`
+	// The maximum number of input bytes encrypted or decrypted, after which
+	// the session expires. Requests which would exceed it are rejected.
+	// Unlimited if omitted.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// A passphrase to derive the session key from, instead of supplying a
+	// key. Cannot be combined with key.
+	Passphrase string `json:"passphrase,omitempty"`
+	// How the key is derived from passphrase. Defaults to argon2id with its
+	// default parameters.
+	KDF *KDFParams `json:"kdf,omitempty"`
+
+	key []byte               // The decoded key, populated by Bind.
+	kdf encryption.KDFParams // The decoded KDF parameters, populated by Bind.
 }
 
 func (sr *SessionRequest) Bind(r *http.Request) error {
-	if strings.TrimSpace(sr.AlgorithmName) == "" {
+	if sr.Session == nil || strings.TrimSpace(sr.AlgorithmName) == "" {
 		return errors.New("algorithm_name is required.")
 	}
-	if strings.TrimSpace(sr.Key) == "key is required." {
-	}
-	sr.AlgorithmName = strings.ReplaceAll(strings.ToLower(sr.AlgorithmName), "-", "")
 
-	var supported bool
-	for _, algo := range encryption.Algorithms() {
-		if algo == sr.AlgorithmName {
-			supported = true
-		}
+	if sr.TTLSeconds < 0 || sr.MaxOperations < 0 || sr.MaxBytes < 0 {
+		return errors.New("ttl_seconds, max_operations and max_bytes must not be negative")
 	}
+
+	name, supported := canonicalAlgorithmName(sr.AlgorithmName)
 	if !supported {
 		return errors.New("unsupported algorithm")
 	}
+	sr.AlgorithmName = name
+
+	var err error
+	sr.KeyEncoding, err = normaliseEncoding("key_encoding", sr.KeyEncoding, encodingRaw, keyEncodings)
+	if err != nil {
+		return err
+	}
+	if sr.Passphrase != "" {
+		if sr.Key != "" {
+			return errors.New("key and passphrase cannot both be supplied")
+		}
+		if sr.KDF == nil {
+			sr.KDF = &KDFParams{}
+		}
+		sr.kdf, err = sr.KDF.decode()
+		return err
+	}
+	if sr.KDF != nil {
+		return errors.New("kdf requires a passphrase")
+	}
+	if sr.GenerateKey() {
+		return nil
+	}
+
+	key, err := decodeString("key", sr.Key, sr.KeyEncoding)
+	if err != nil {
+		return err
+	}
 
-	if !encryption.ValidateAlgoKeyPair(algorithmFromText(sr.AlgorithmName), []byte(sr.Key)) {
-		return errors.New("invalid key size")
+	if err := validateKey(sr.AlgorithmName, key); err != nil {
+		return err
 	}
+	sr.key = key
 
 	return nil
 }
 
+// validateKey returns an error describing the valid key sizes if key is not a
+// valid key for the named algorithm.
+func validateKey(algorithm string, key []byte) error {
+	algo := encryption.Algorithm(algorithm)
+	if !encryption.ValidateAlgoKeyPair(algo, key) {
+		reg, _ := encryption.Lookup(algo)
+		return fmt.Errorf("invalid key size: %s requires a key of %s bytes, got %d",
+			algorithm,
+			joinInts(reg.KeySizes, " or "),
+			len(key))
+	}
+	return nil
+}
+
+// GenerateKey returns true if the client supplied neither a key nor a
+// passphrase and the server should generate a key.
+func (sr *SessionRequest) GenerateKey() bool {
+	return sr.Key == "" && sr.Passphrase == ""
+}
+
+// Limits returns the session limits requested.
+func (sr *SessionRequest) Limits() sessionstore.Limits {
+	return sessionstore.Limits{
+		TTL:           time.Duration(sr.TTLSeconds) * time.Second,
+		MaxOperations: sr.MaxOperations,
+		MaxBytes:      sr.MaxBytes,
+	}
+}
+
+// ShouldReturnKey returns true if a server generated key should be included
+// in the response.
+func (sr *SessionRequest) ShouldReturnKey() bool {
+	return sr.GenerateKey() && (sr.ReturnKey == nil || *sr.ReturnKey)
+}
+
+// KDFParams describes how a session key is derived from a passphrase.
+//
+// @Description Key derivation function and parameters. Omitted parameters
+// @Description take the defaults for the function.
+type KDFParams struct {
+	// The key derivation function: one of argon2id (the default), scrypt or
+	// pbkdf2 (HMAC-SHA-256).
+	Name string `json:"name,omitempty"`
+	// The salt, base64 encoded and at least 8 bytes long. A random 16 byte
+	// salt is generated if omitted.
+	Salt string `json:"salt,omitempty"`
+	// Argon2id: the number of passes over memory (default 2, at most 16).
+	Time uint32 `json:"time,omitempty"`
+	// Argon2id: the memory used in KiB (default 19456, at most 65536).
+	MemoryKiB uint32 `json:"memory_kib,omitempty"`
+	// Argon2id: the degree of parallelism (default 1, at most 4).
+	Threads uint8 `json:"threads,omitempty"`
+	// scrypt: the CPU and memory cost, a power of two (default 131072).
+	N int `json:"n,omitempty"`
+	// scrypt: the block size (default 8).
+	R int `json:"r,omitempty"`
+	// scrypt: the parallelisation (default 1, at most 4).
+	P int `json:"p,omitempty"`
+	// PBKDF2: the number of iterations (default 600000).
+	Iterations int `json:"iterations,omitempty"`
+}
+
+// decode checks the KDF name and decodes the salt.
+func (kp *KDFParams) decode() (encryption.KDFParams, error) {
+	name := strings.ToLower(strings.TrimSpace(kp.Name))
+	if name == "" {
+		name = string(encryption.Argon2id)
+	}
+	if !slices.Contains(encryption.KDFs(), name) {
+		return encryption.KDFParams{}, fmt.Errorf("unsupported kdf %q; expected one of %s",
+			kp.Name, strings.Join(encryption.KDFs(), ", "))
+	}
+
+	salt, err := decodeString("salt", kp.Salt, encodingBase64)
+	if err != nil {
+		return encryption.KDFParams{}, err
+	}
+
+	return encryption.KDFParams{
+		KDF:        encryption.KDF(name),
+		Salt:       salt,
+		Time:       kp.Time,
+		Memory:     kp.MemoryKiB,
+		Threads:    kp.Threads,
+		N:          kp.N,
+		R:          kp.R,
+		P:          kp.P,
+		Iterations: kp.Iterations,
+	}, nil
+}
+
+// newKDFParams returns the description of a key derivation, leaving out the
+// parameters of other KDFs.
+func newKDFParams(p encryption.KDFParams) *KDFParams {
+	kp := &KDFParams{
+		Name: string(p.KDF),
+		Salt: base64.StdEncoding.EncodeToString(p.Salt),
+	}
+	switch p.KDF {
+	case encryption.Argon2id:
+		kp.Time, kp.MemoryKiB, kp.Threads = p.Time, p.Memory, p.Threads
+	case encryption.Scrypt:
+		kp.N, kp.R, kp.P = p.N, p.R, p.P
+	case encryption.PBKDF2:
+		kp.Iterations = p.Iterations
+	}
+	return kp
+}
+
 // SessionResponse is the 200 response for calls to create session.
 //
 // @Description Contains the session ID which can be used in calls to
 // @Description encrypt and decrypt input.
 type SessionResponse struct {
-	ID string `json:"id"` // The session ID.
+	ID        string    `json:"id"`         // The session ID.
+	ExpiresAt time.Time `json:"expires_at"` // When the session expires unless refreshed.
+	// The server generated key, encoded as requested by key_encoding. Only
+	// present on creation of a session with a generated key and never
+	// returned again.
+	Key string `json:"key,omitempty"`
+	// How the key was derived from the passphrase, salt included. Only
+	// present on creation of a session from a passphrase; supply it again
+	// with the same passphrase to derive the same key.
+	KDF *KDFParams `json:"kdf,omitempty"`
 }
 
 func (sr *SessionResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
+
+// SessionInfoResponse is the 200 response for calls to inspect or refresh a
+// session.
+//
+// @Description Describes an encryption session. The session keys are never
+// @Description included, only their IDs.
+type SessionInfoResponse struct {
+	ID             string    `json:"id"`                       // The session ID.
+	AlgorithmName  string    `json:"algorithm"`                // The algorithm associated with the session.
+	CreatedAt      time.Time `json:"created_at"`               // When the session was created.
+	ExpiresAt      time.Time `json:"expires_at"`               // When the session expires unless refreshed.
+	TTLSeconds     int64     `json:"ttl_seconds"`              // The session lifetime, applied again on refresh.
+	UsageCount     int64     `json:"usage_count"`              // The number of successful encrypt and decrypt operations.
+	BytesProcessed int64     `json:"bytes_processed"`          // The number of input bytes encrypted or decrypted.
+	MaxOperations  int64     `json:"max_operations,omitempty"` // The operation limit, if any.
+	MaxBytes       int64     `json:"max_bytes,omitempty"`      // The byte limit, if any.
+	PrimaryKeyID   string    `json:"primary_key_id"`           // The ID of the key used to encrypt.
+	KeyIDs         []string  `json:"key_ids"`                  // The IDs of every key held, primary key first.
+}
+
+func newSessionInfoResponse(s *sessionstore.Session) *SessionInfoResponse {
+	keyIDs := s.KeyIDs()
+	return &SessionInfoResponse{
+		ID:             s.ID,
+		AlgorithmName:  s.AlgorithmName,
+		CreatedAt:      s.CreatedAt,
+		ExpiresAt:      s.ExpiresAt,
+		TTLSeconds:     int64(s.TTL / time.Second),
+		UsageCount:     s.UsageCount,
+		BytesProcessed: s.BytesProcessed,
+		MaxOperations:  s.MaxOperations,
+		MaxBytes:       s.MaxBytes,
+		PrimaryKeyID:   keyIDs[0],
+		KeyIDs:         keyIDs,
+	}
+}
+
+func (sr *SessionInfoResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// KeyRequest is the body to the session keys endpoint.
+//
+// @Description Used for adding a key to, or promoting a key within, the
+// @Description keyring of an encryption session.
+type KeyRequest struct {
+	// The key to add. If neither key nor key_id is supplied the server
+	// generates a random key of the correct size for the session algorithm.
+	Key string `json:"key,omitempty"`
+	// The encoding of key: one of raw (the default), base64, base64url or
+	// hex. A generated key is returned in this encoding, with raw falling
+	// back to base64.
+	KeyEncoding string `json:"key_encoding,omitempty"`
+	// The ID of a key the session already holds, to make it the primary key.
+	// Cannot be combined with key.
+	KeyID string `json:"key_id,omitempty"`
+	// Whether an added key becomes the primary key, used for all further
+	// encryption. Defaults to false, leaving the key usable for decryption
+	// only until it is promoted.
+	Promote bool `json:"promote,omitempty"`
+	// Whether a server generated key is returned in the response. Defaults
+	// to true. Ignored when a key is supplied.
+	ReturnKey *bool `json:"return_key,omitempty"`
+
+	key []byte // The decoded key, populated by Bind.
+}
+
+func (kr *KeyRequest) Bind(r *http.Request) error {
+	if kr.Key != "" && kr.KeyID != "" {
+		return errors.New("key and key_id cannot both be supplied")
+	}
+
+	var err error
+	kr.KeyEncoding, err = normaliseEncoding("key_encoding", kr.KeyEncoding, encodingRaw, keyEncodings)
+	if err != nil {
+		return err
+	}
+	if kr.Key == "" {
+		return nil
+	}
+
+	kr.key, err = decodeString("key", kr.Key, kr.KeyEncoding)
+	return err
+}
+
+// GenerateKey returns true if the client supplied neither a key nor a key ID
+// and the server should generate a key.
+func (kr *KeyRequest) GenerateKey() bool {
+	return kr.Key == "" && kr.KeyID == ""
+}
+
+// ShouldReturnKey returns true if a server generated key should be included
+// in the response.
+func (kr *KeyRequest) ShouldReturnKey() bool {
+	return kr.GenerateKey() && (kr.ReturnKey == nil || *kr.ReturnKey)
+}
+
+// KeyResponse is the 200 response for calls to the session keys endpoint.
+//
+// @Description Describes the keyring of an encryption session after a key
+// @Description was added or promoted.
+type KeyResponse struct {
+	KeyID        string   `json:"key_id"`         // The ID of the key added or promoted.
+	PrimaryKeyID string   `json:"primary_key_id"` // The ID of the key used to encrypt.
+	KeyIDs       []string `json:"key_ids"`        // The IDs of every key held, primary key first.
+	// The server generated key, encoded as requested by key_encoding. Only
+	// present when a key was generated and never returned again.
+	Key string `json:"key,omitempty"`
+}
+
+func (kr *KeyResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
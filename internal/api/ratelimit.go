@@ -0,0 +1,157 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitSweepInterval is how often idle clients are forgotten.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit configures a token bucket: clients may make Burst requests at
+// once, refilled at PerSecond requests per second. A zero PerSecond disables
+// the limit.
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// rateLimiter holds a token bucket per client. Clients are forgotten once
+// their bucket would have refilled, so memory is bounded by the number of
+// recently active clients.
+type rateLimiter struct {
+	name  string // Used in metrics.
+	limit RateLimit
+
+	mu        sync.Mutex
+	clients   map[string]*rateLimitClient
+	lastSweep time.Time
+}
+
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(name string, limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		name:      name,
+		limit:     limit,
+		clients:   make(map[string]*rateLimitClient),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of the given client. If none is
+// available false is returned along with how long until one will be.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reservation := l.client(key, now).ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, rateLimitSweepInterval // Burst is zero, so never.
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// wait returns how long until the bucket of the given client holds a token,
+// which is zero if it does now, without taking one.
+func (l *rateLimiter) wait(key string) time.Duration {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tokens := l.client(key, now).TokensAt(now)
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / l.limit.PerSecond * float64(time.Second))
+}
+
+// client returns the bucket of the given client, creating it if need be. l.mu
+// must be held.
+func (l *rateLimiter) client(key string, now time.Time) *rate.Limiter {
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateLimitClient{limiter: rate.NewLimiter(rate.Limit(l.limit.PerSecond), l.limit.Burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+// sweep forgets clients whose buckets have refilled since they were last
+// seen, as a new bucket is equivalent.
+func (l *rateLimiter) sweep(now time.Time) {
+	refill := time.Duration(float64(l.limit.Burst) / l.limit.PerSecond * float64(time.Second))
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) > refill {
+			delete(l.clients, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimit returns middleware which rejects requests exceeding limit with a
+// 429 and a Retry-After header. Clients are identified by their authenticated
+// principal or, failing that, their IP address, so it must run after
+// authenticate. A nil limiter allows every request.
+func (h *Handlers) rateLimit(l *rateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, retryAfter := l.allow(rateLimitKey(r)); !ok {
+				tooManyRequests(w, r, l, retryAfter)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tooManyRequests responds to a request rejected by l with a 429, telling the
+// client to retry after the given delay.
+func tooManyRequests(w http.ResponseWriter, r *http.Request, l *rateLimiter, retryAfter time.Duration) {
+	rateLimited.WithLabelValues(l.name).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	render.Render(w, r, ErrTooManyRequests())
+}
+
+// rateLimitKey identifies the client making a request for rate limiting.
+func rateLimitKey(r *http.Request) string {
+	if p := principal(r); p != "" {
+		return "principal:" + p
+	}
+	return remoteIPKey(r)
+}
+
+// remoteIPKey identifies the client making a request by the IP address the
+// request came from. Behind a proxy this is the proxy's address.
+func remoteIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// slowRate refills so slowly that no token is returned during a test.
+const slowRate = 0.001
+
+func TestRateLimit(t *testing.T) {
+	h := newTestHandlers(t, newTestAPIKeys(t), Options{RateLimit: RateLimit{PerSecond: slowRate, Burst: 2}})
+
+	for i := 0; i < 2; i++ {
+		if w := do(h, http.MethodGet, sessionPath+"missing", nil, apiKeyHeader, "etl-key"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected request %d within the burst to get 404, got %d", i, w.Code)
+		}
+	}
+
+	t.Run("Over the burst", func(t *testing.T) {
+		w := do(h, http.MethodGet, sessionPath+"missing", nil, apiKeyHeader, "etl-key")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected 429, got %d", w.Code)
+		}
+		if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter < 1 {
+			t.Errorf("expected a Retry-After of at least a second, got %q", w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("Other principal", func(t *testing.T) {
+		if w := do(h, http.MethodGet, sessionPath+"missing", nil, apiKeyHeader, "billing-key"); w.Code != http.StatusNotFound {
+			t.Errorf("expected another principal to have its own bucket, got %d", w.Code)
+		}
+	})
+
+	t.Run("Algorithms are not limited", func(t *testing.T) {
+		if w := do(h, http.MethodGet, "/api/v1/algorithms/", nil); w.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", w.Code)
+		}
+	})
+}
+
+func TestRateLimit_SessionCreate(t *testing.T) {
+	h := newTestHandlers(t, nil, Options{SessionCreateRateLimit: RateLimit{PerSecond: slowRate, Burst: 1}})
+	id := newSession(t, h, map[string]any{"algorithm": "aes128-gcm"})
+
+	if w := do(h, http.MethodPost, sessionPath, map[string]any{"algorithm": "aes128-gcm"}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", w.Code)
+	}
+	if w := do(h, http.MethodGet, sessionPath+id, nil); w.Code != http.StatusOK {
+		t.Errorf("expected other endpoints to be unaffected, got %d", w.Code)
+	}
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	h := newTestHandlers(t, nil, Options{})
+	for i := 0; i < 50; i++ {
+		if w := do(h, http.MethodPost, sessionPath, map[string]any{"algorithm": "aes128-gcm"}); w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", w.Code)
+		}
+	}
+}
+
+func TestRateLimit_AuthFailures(t *testing.T) {
+	h := newTestHandlers(t, newTestAPIKeys(t), Options{AuthFailureRateLimit: RateLimit{PerSecond: slowRate, Burst: 3}})
+
+	// fromIP sends a request for a missing session from the given address.
+	fromIP := func(ip, key string) int {
+		req := newRequest(http.MethodGet, sessionPath+"missing", nil, apiKeyHeader, key)
+		req.RemoteAddr = ip + ":1234"
+		return serve(h, req).Code
+	}
+
+	t.Run("Missing keys are not counted", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			if code := fromIP("192.0.2.1", ""); code != http.StatusUnauthorized {
+				t.Fatalf("expected 401, got %d", code)
+			}
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		if code := fromIP("192.0.2.1", "guess"); code != http.StatusUnauthorized {
+			t.Fatalf("expected failed attempt %d within the burst to get 401, got %d", i, code)
+		}
+	}
+
+	t.Run("Further guesses", func(t *testing.T) {
+		if code := fromIP("192.0.2.1", "guess"); code != http.StatusTooManyRequests {
+			t.Errorf("expected 429, got %d", code)
+		}
+	})
+
+	t.Run("Valid key from the same address", func(t *testing.T) {
+		if code := fromIP("192.0.2.1", "etl-key"); code != http.StatusTooManyRequests {
+			t.Errorf("expected 429, got %d", code)
+		}
+	})
+
+	t.Run("Other address", func(t *testing.T) {
+		if code := fromIP("192.0.2.2", "etl-key"); code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", code)
+		}
+	})
+
+	t.Run("Successful attempts are not counted", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			if code := fromIP("192.0.2.3", "etl-key"); code != http.StatusNotFound {
+				t.Fatalf("expected 404, got %d", code)
+			}
+		}
+	})
+}
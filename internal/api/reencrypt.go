@@ -0,0 +1,100 @@
+package api
+
+import (
+	"atostechtest/internal/sessionstore"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Re-encrypts a cipher text from one session under another.
+//
+//	@Summary		Re-encrypt cipher text under another session.
+//	@Description	Decrypt a cipher text in the context of the source session and encrypt the result in the context of
+//	@Description	the target session, returning the new cipher text. The plaintext never leaves the service, which
+//	@Description	makes this suitable for migrating data between algorithms or keys; giving the source session as the
+//	@Description	target re-encrypts under its primary key. Both sessions must belong to the caller. The operation
+//	@Description	counts as a decrypt against the source session and an encrypt against the target session.
+//	@Description	The cipher text is read as base64 unless ciphertext_encoding says otherwise, and returned base64
+//	@Description	encoded unless output_encoding is hex.
+//	@Tags			encryption, session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		string				false	"The source encryption session ID"
+//	@Param			request		body		ReencryptRequest	true	"Request body"
+//	@Success		200			{object}	EncryptResponse
+//	@Failure		400			{object}	ErrResponse
+//	@Failure		401			{object}	ErrResponse
+//	@Failure		404			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
+//	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}/reencrypt   [post]
+func (h *Handlers) createReencrypt(w http.ResponseWriter, r *http.Request) {
+	data := &ReencryptRequest{}
+	if err := bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	source := r.Context().Value("session").(*sessionstore.Session)
+	target, err := h.sessionStore.GetSession(r.Context(), data.TargetSessionID)
+	if err == nil && target.Owner != principal(r) {
+		err = sessionstore.ErrSessionNotFound // Do not reveal other principals' sessions.
+	}
+	if err == sessionstore.ErrSessionNotFound || err == sessionstore.ErrSessionExpired {
+		errResp := ErrNotFound()
+		errResp.ErrorText = "target session not found"
+		render.Render(w, r, errResp)
+		return
+	}
+	if err != nil {
+		render.Render(w, r, h.ErrInternalServer(err))
+		return
+	}
+
+	decrypted := sessionstore.Usage{Operations: 1, Bytes: int64(len(data.cipherText))}
+	if !h.reserveUsage(w, r, source, decrypted) {
+		return
+	}
+
+	_, span := tracer.Start(r.Context(), "encryption.Reencrypt", trace.WithAttributes(
+		attribute.String("session.algorithm", source.AlgorithmName),
+		attribute.String("session.target_algorithm", target.AlgorithmName)))
+	defer span.End()
+	plaintext, errResp := h.open(source, data.Context, data.cipherText, []byte(data.AAD))
+	if errResp != nil {
+		h.recordUsage(r.Context(), source, "decrypt", decrypted, sessionstore.Usage{})
+		render.Render(w, r, errResp)
+		return
+	}
+
+	// The plaintext size is only known once decrypted, so the target
+	// session's usage is reserved in a second step.
+	encrypted := sessionstore.Usage{Operations: 1, Bytes: int64(len(plaintext))}
+	if !h.reserveUsage(w, r, target, encrypted) {
+		h.recordUsage(r.Context(), source, "decrypt", decrypted, sessionstore.Usage{})
+		return
+	}
+	cipherText, errResp := h.seal(target, data.TargetContext, plaintext, []byte(data.TargetAAD))
+	if errResp != nil {
+		h.recordUsage(r.Context(), source, "decrypt", decrypted, sessionstore.Usage{})
+		h.recordUsage(r.Context(), target, "encrypt", encrypted, sessionstore.Usage{})
+		render.Render(w, r, errResp)
+		return
+	}
+
+	h.recordUsage(r.Context(), source, "decrypt", decrypted, decrypted)
+	h.recordUsage(r.Context(), target, "encrypt", encrypted, encrypted)
+
+	encoded, err := encodeBytes(cipherText, data.OutputEncoding)
+	if err != nil {
+		render.Render(w, r, h.ErrInternalServer(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, EncryptResponse{CipherText: encoded})
+}
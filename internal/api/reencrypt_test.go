@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+func TestReencrypt(t *testing.T) {
+	h := newTestHandlers(t, newTestAPIKeys(t), Options{})
+	auth := []string{apiKeyHeader, "etl-key"}
+	source := newSession(t, h, map[string]any{"algorithm": "aes256-gcm"}, auth...)
+	target := newSession(t, h, map[string]any{"algorithm": "chacha20-poly1305"}, auth...)
+	other := newSession(t, h, map[string]any{"algorithm": "chacha20-poly1305"}, apiKeyHeader, "billing-key")
+
+	encrypt := func(t *testing.T, id string) string {
+		t.Helper()
+		w := do(h, http.MethodPost, sessionPath+id+"/encrypt",
+			map[string]any{"plaintext": "Ah-nold", "aad": "header", "context": "billing"}, auth...)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var encrypted EncryptResponse
+		decode(t, w, &encrypted)
+		return encrypted.CipherText
+	}
+	reencrypt := func(t *testing.T, id string, body map[string]any) (string, int) {
+		t.Helper()
+		w := do(h, http.MethodPost, sessionPath+id+"/reencrypt", body, auth...)
+		var encrypted EncryptResponse
+		if w.Code == http.StatusOK {
+			decode(t, w, &encrypted)
+		}
+		return encrypted.CipherText, w.Code
+	}
+	usage := func(t *testing.T, id string) SessionInfoResponse {
+		t.Helper()
+		var info SessionInfoResponse
+		decode(t, do(h, http.MethodGet, sessionPath+id, nil, auth...), &info)
+		return info
+	}
+	cipherText := encrypt(t, source)
+
+	t.Run("Round trip across algorithms", func(t *testing.T) {
+		reencrypted, code := reencrypt(t, source, map[string]any{
+			"ciphertext":        cipherText,
+			"aad":               "header",
+			"context":           "billing",
+			"target_session_id": target,
+			"target_aad":        "footer",
+			"target_context":    "payroll",
+		})
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		w := do(h, http.MethodPost, sessionPath+target+"/decrypt",
+			map[string]any{"ciphertext": reencrypted, "aad": "footer", "context": "payroll"}, auth...)
+		var decrypted DecryptResponse
+		decode(t, w, &decrypted)
+		if w.Code != http.StatusOK || decrypted.Plaintext != "Ah-nold" {
+			t.Errorf("expected plaintext %q, got %d: %s", "Ah-nold", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Same session", func(t *testing.T) {
+		reencrypted, code := reencrypt(t, source, map[string]any{
+			"ciphertext":        cipherText,
+			"aad":               "header",
+			"context":           "billing",
+			"target_session_id": source,
+		})
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if reencrypted == cipherText {
+			t.Error("expected a fresh cipher text")
+		}
+
+		w := do(h, http.MethodPost, sessionPath+source+"/decrypt",
+			map[string]any{"ciphertext": reencrypted}, auth...)
+		var decrypted DecryptResponse
+		decode(t, w, &decrypted)
+		if w.Code != http.StatusOK || decrypted.Plaintext != "Ah-nold" {
+			t.Errorf("expected plaintext %q, got %d: %s", "Ah-nold", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Usage recorded on both sessions", func(t *testing.T) {
+		source := newSession(t, h, map[string]any{"algorithm": "aes256-gcm"}, auth...)
+		target := newSession(t, h, map[string]any{"algorithm": "chacha20-poly1305"}, auth...)
+		cipherText := encrypt(t, source)
+		decoded, _ := base64.StdEncoding.DecodeString(cipherText)
+
+		if _, code := reencrypt(t, source, map[string]any{
+			"ciphertext":        cipherText,
+			"aad":               "header",
+			"context":           "billing",
+			"target_session_id": target,
+		}); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		// The source session counts the encrypt as well as the decrypt.
+		if info := usage(t, source); info.UsageCount != 2 || info.BytesProcessed != int64(7+len(decoded)) {
+			t.Errorf("expected source usage count of 2 and %d bytes processed, got %d and %d",
+				7+len(decoded),
+				info.UsageCount,
+				info.BytesProcessed)
+		}
+		if info := usage(t, target); info.UsageCount != 1 || info.BytesProcessed != 7 {
+			t.Errorf("expected target usage count of 1 and 7 bytes processed, got %d and %d",
+				info.UsageCount,
+				info.BytesProcessed)
+		}
+	})
+
+	testCases := []struct {
+		name   string
+		body   map[string]any
+		status int
+	}{
+		{"Missing target", map[string]any{"aad": "header", "context": "billing", "target_session_id": "missing"}, http.StatusNotFound},
+		{"Another principal's target", map[string]any{"aad": "header", "context": "billing", "target_session_id": other}, http.StatusNotFound},
+		{"Wrong AAD", map[string]any{"aad": "footer", "context": "billing", "target_session_id": target}, http.StatusBadRequest},
+		{"Missing AAD", map[string]any{"context": "billing", "target_session_id": target}, http.StatusBadRequest},
+		{"Wrong context", map[string]any{"aad": "header", "context": "payroll", "target_session_id": target}, http.StatusBadRequest},
+		{"Missing context", map[string]any{"aad": "header", "target_session_id": target}, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			before, beforeTarget := usage(t, source), usage(t, target)
+			tc.body["ciphertext"] = cipherText
+			if _, code := reencrypt(t, source, tc.body); code != tc.status {
+				t.Fatalf("expected %d, got %d", tc.status, code)
+			}
+
+			// Failed requests are not counted against either session.
+			if after := usage(t, source); after.UsageCount != before.UsageCount || after.BytesProcessed != before.BytesProcessed {
+				t.Errorf("expected source usage to be unchanged, got %+v", after)
+			}
+			if after := usage(t, target); after.UsageCount != beforeTarget.UsageCount || after.BytesProcessed != beforeTarget.BytesProcessed {
+				t.Errorf("expected target usage to be unchanged, got %+v", after)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"atostechtest/internal/encryption"
+	"atostechtest/internal/sessionstore"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const contentTypeOctetStream = "application/octet-stream"
+
+// Encrypts a request body of any size as a stream of authenticated segments.
+//
+//	@Summary		Encrypt a stream.
+//	@Description	Encrypt an application/octet-stream request body in the context of a specific encryption session.
+//	@Description	The body is encrypted chunk by chunk and streamed back as binary framed cipher text, so arbitrarily
+//	@Description	large inputs are handled in constant memory. Only authenticated (AEAD) algorithms support streaming.
+//	@Description	The output can only be decrypted by the decrypt stream endpoint.
+//	@Tags			encryption, session, stream
+//	@Accept			octet-stream
+//	@Produce		octet-stream
+//	@Param			session_id	path		string	false	"An encryption session ID"
+//	@Param			context		query		string	false	"A context label to encrypt under a sub-key for"
+//	@Param			request		body		string	true	"Plaintext bytes"
+//	@Success		200			{file}		binary
+//	@Failure		400			{object}	ErrResponse
+//	@Failure		401			{object}	ErrResponse
+//	@Failure		404			{object}	ErrResponse
+//	@Failure		415			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
+//	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}/encrypt/stream   [post]
+func (h *Handlers) createEncryptStream(w http.ResponseWriter, r *http.Request) {
+	if !h.checkOctetStream(w, r) {
+		return
+	}
+
+	s := r.Context().Value("session").(*sessionstore.Session)
+	label, ok := h.streamContext(w, r)
+	if !ok {
+		return
+	}
+	key, keyID, err := s.EncryptionKey(label)
+	if err != nil {
+		render.Render(w, r, h.ErrInternalServer(err))
+		return
+	}
+	body, reserved, ok := h.reserveStream(w, r, s, "encrypt")
+	if !ok {
+		return
+	}
+	out := &countingWriter{w: w}
+	enc, err := encryption.NewStreamEncrypter(
+		encryption.Algorithm(s.AlgorithmName),
+		[]byte(key),
+		keyID,
+		out,
+	)
+	if err != nil {
+		h.recordUsage(r.Context(), s, "encrypt", reserved, sessionstore.Usage{})
+		if errors.Is(err, encryption.ErrStreamingNotSupported) {
+			render.Render(w, r, ErrInvalidRequest(err))
+		} else {
+			render.Render(w, r, h.ErrInternalServer(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeOctetStream)
+	_, span := tracer.Start(r.Context(), "encryption.EncryptStream",
+		trace.WithAttributes(attribute.String("session.algorithm", s.AlgorithmName)))
+	defer span.End()
+	_, err = io.Copy(enc, body)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		h.recordUsage(r.Context(), s, "encrypt", reserved, sessionstore.Usage{Operations: 1, Bytes: body.n})
+		return
+	}
+	h.recordUsage(r.Context(), s, "encrypt", reserved, sessionstore.Usage{})
+
+	// Nothing has been written yet, as the encrypter holds back a whole
+	// segment, so a proper error response can be sent.
+	if out.n == 0 {
+		w.Header().Del("Content-Type")
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	// The final segment is only written by a successful Close, so the client
+	// cannot mistake the output for a complete stream.
+	h.abortStream("encrypting stream", err)
+}
+
+// Decrypts a framed cipher text stream produced by the encrypt stream endpoint.
+//
+//	@Summary		Decrypt a stream.
+//	@Description	Decrypt an application/octet-stream request body produced by the encrypt stream endpoint in the
+//	@Description	context of a specific encryption session. Plaintext is streamed back as each segment is
+//	@Description	authenticated. If the stream is found to be tampered with or truncated after output has begun the
+//	@Description	connection is aborted, so clients must treat an incomplete response as a failure.
+//	@Description	Streams record the ID of the key they were encrypted with, so they can still be decrypted after a key
+//	@Description	rotation.
+//	@Tags			encryption, session, stream
+//	@Accept			octet-stream
+//	@Produce		octet-stream
+//	@Param			session_id	path		string	false	"An encryption session ID"
+//	@Param			context		query		string	false	"The context label given at encryption time"
+//	@Param			request		body		string	true	"Framed cipher text bytes"
+//	@Success		200			{file}		binary
+//	@Failure		400			{object}	ErrResponse
+//	@Failure		401			{object}	ErrResponse
+//	@Failure		404			{object}	ErrResponse
+//	@Failure		415			{object}	ErrResponse
+//	@Failure		429			{object}	ErrResponse
+//	@Failure		500			{object}	ErrResponse
+//	@Security		ApiKeyAuth
+//	@Router			/session/{session_id}/decrypt/stream   [post]
+func (h *Handlers) createDecryptStream(w http.ResponseWriter, r *http.Request) {
+	if !h.checkOctetStream(w, r) {
+		return
+	}
+
+	s := r.Context().Value("session").(*sessionstore.Session)
+	label, ok := h.streamContext(w, r)
+	if !ok {
+		return
+	}
+	body, reserved, ok := h.reserveStream(w, r, s, "decrypt")
+	if !ok {
+		return
+	}
+
+	// The key is picked by the ID in the stream header. Any error peeking is
+	// met again by the decrypter.
+	in := bufio.NewReader(body)
+	header, _ := in.Peek(encryption.HeaderSize)
+	key, keyID, err := s.DecryptionKey(header, label)
+	if err != nil {
+		h.recordUsage(r.Context(), s, "decrypt", reserved, sessionstore.Usage{})
+		render.Render(w, r, h.ErrInternalServer(err))
+		return
+	}
+	dec, err := encryption.NewStreamDecrypter(
+		encryption.Algorithm(s.AlgorithmName),
+		[]byte(key),
+		keyID,
+		in,
+	)
+	if err != nil {
+		h.recordUsage(r.Context(), s, "decrypt", reserved, sessionstore.Usage{})
+		if errors.Is(err, encryption.ErrStreamingNotSupported) {
+			render.Render(w, r, ErrInvalidRequest(err))
+		} else {
+			render.Render(w, r, h.ErrInternalServer(err))
+		}
+		return
+	}
+
+	// The writer is wrapped to hide any io.ReaderFrom implementation, which
+	// may send the response header before the first segment is authenticated.
+	w.Header().Set("Content-Type", contentTypeOctetStream)
+	_, span := tracer.Start(r.Context(), "encryption.DecryptStream",
+		trace.WithAttributes(attribute.String("session.algorithm", s.AlgorithmName)))
+	n, err := io.Copy(struct{ io.Writer }{w}, dec)
+	span.End()
+	if err == nil {
+		h.recordUsage(r.Context(), s, "decrypt", reserved, sessionstore.Usage{Operations: 1, Bytes: body.n})
+		return
+	}
+	h.recordUsage(r.Context(), s, "decrypt", reserved, sessionstore.Usage{})
+
+	// Nothing has been written yet so a proper error response can be sent.
+	if n == 0 {
+		w.Header().Del("Content-Type")
+		if errors.Is(err, encryption.ErrAuthenticationFailed) ||
+			errors.Is(err, encryption.ErrStreamTruncated) ||
+			errors.Is(err, encryption.ErrAlgorithmMismatch) ||
+			errors.Is(err, encryption.ErrKeyMismatch) ||
+			errors.Is(err, sessionstore.ErrUsageLimitExceeded) {
+			render.Render(w, r, ErrInvalidRequest(err))
+		} else {
+			render.Render(w, r, h.ErrInternalServer(err))
+		}
+		return
+	}
+	h.abortStream("decrypting stream", err)
+}
+
+// checkOctetStream ensures the request body is declared as binary and enables
+// full duplex so the response can be written whilst the body is still being
+// read. It writes an error response and returns false if the request cannot
+// be streamed.
+func (h *Handlers) checkOctetStream(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeOctetStream {
+		render.Render(w, r, &ErrResponse{
+			HTTPStatusCode: http.StatusUnsupportedMediaType,
+			StatusText:     http.StatusText(http.StatusUnsupportedMediaType),
+			ErrorText:      fmt.Sprintf("content type must be %s", contentTypeOctetStream),
+		})
+		return false
+	}
+
+	// Not all ResponseWriters support this (HTTP/2 is always full duplex) so
+	// the error is ignored.
+	_ = http.NewResponseController(w).EnableFullDuplex()
+
+	return true
+}
+
+// streamContext returns the context label to stream under, from the context
+// query parameter. If it is invalid an error response is sent and false
+// returned.
+func (h *Handlers) streamContext(w http.ResponseWriter, r *http.Request) (string, bool) {
+	label := r.URL.Query().Get("context")
+	if err := checkContext("context", label); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return "", false
+	}
+	return label, true
+}
+
+// reserveStream reserves one operation on all the input bytes a session has
+// left for a streamed request body (see sessionstore.Store.ReserveStream). It
+// returns the body, which fails with sessionstore.ErrUsageLimitExceeded if
+// read past the bytes reserved, along with the reservation. A body declaring a
+// larger Content-Length is rejected up front. If the reservation cannot be
+// made an error response is sent and false returned.
+func (h *Handlers) reserveStream(w http.ResponseWriter, r *http.Request, s *sessionstore.Session, operation string) (*countingReader, sessionstore.Usage, bool) {
+	limit, err := h.sessionStore.ReserveStream(r.Context(), s.ID)
+	if err != nil {
+		render.Render(w, r, h.usageErrResponse(err))
+		return nil, sessionstore.Usage{}, false
+	}
+
+	reserved := sessionstore.Usage{Operations: 1, Bytes: limit}
+	if limit == 0 {
+		return &countingReader{r: r.Body}, reserved, true
+	}
+	if r.ContentLength > limit {
+		h.recordUsage(r.Context(), s, operation, reserved, sessionstore.Usage{})
+		render.Render(w, r, ErrInvalidRequest(sessionstore.ErrUsageLimitExceeded))
+		return nil, sessionstore.Usage{}, false
+	}
+	// One byte more than reserved is let through to detect an overrun.
+	return &countingReader{r: io.LimitReader(r.Body, limit+1), limit: limit}, reserved, true
+}
+
+// abortStream is used once a streamed response has begun and can no longer be
+// turned into an error response. Panicking with http.ErrAbortHandler makes
+// the server drop the connection so the client sees an incomplete response
+// rather than a seemingly successful one.
+func (h *Handlers) abortStream(msg string, err error) {
+	h.logger.Error(msg, "err", err)
+	panic(http.ErrAbortHandler)
+}
+
+// countingReader counts the bytes read through it. If limit is positive,
+// reading more than limit bytes fails with sessionstore.ErrUsageLimitExceeded.
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.limit > 0 && c.n > c.limit {
+		return n, sessionstore.ErrUsageLimitExceeded
+	}
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
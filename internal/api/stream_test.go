@@ -0,0 +1,111 @@
+package api
+
+import (
+	"atostechtest/internal/datastore"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamKeyRotation(t *testing.T) {
+	h := newTestHandlers(t, nil, Options{})
+	id := newSession(t, h, map[string]any{"algorithm": "aes256-gcm"})
+	plaintext := []byte("It's not a tumor!")
+
+	encryptStream := func(t *testing.T, path string) []byte {
+		t.Helper()
+		w := do(h, http.MethodPost, sessionPath+id+path, plaintext)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return w.Body.Bytes()
+	}
+	plain := encryptStream(t, "/encrypt/stream")
+	billing := encryptStream(t, "/encrypt/stream?context=billing")
+
+	if w := do(h, http.MethodPost, sessionPath+id+"/keys", map[string]any{"promote": true}); w.Code != http.StatusOK {
+		t.Fatalf("expected a new key to be promoted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	testCases := []struct {
+		name       string
+		path       string
+		cipherText []byte
+		status     int
+	}{
+		{"Encrypted under the previous key", "/decrypt/stream", plain, http.StatusOK},
+		{"Encrypted under a sub-key of the previous key", "/decrypt/stream?context=billing", billing, http.StatusOK},
+		{"Wrong context", "/decrypt/stream?context=payroll", billing, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := do(h, http.MethodPost, sessionPath+id+tc.path, tc.cipherText)
+			if w.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if tc.status == http.StatusOK && !bytes.Equal(w.Body.Bytes(), plaintext) {
+				t.Errorf("expected %q, got %q", plaintext, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestEncryptStream_UsageLimits(t *testing.T) {
+	h := newTestHandlers(t, nil, Options{})
+	id := newSession(t, h, map[string]any{"algorithm": "aes256-gcm", "max_bytes": 16})
+
+	// A chunked body declares no length, so the overrun is only found whilst
+	// reading it.
+	req := newRequest(http.MethodPost, sessionPath+id+"/encrypt/stream", bytes.Repeat([]byte("a"), 64))
+	req.ContentLength = -1
+	w := serve(h, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Nothing was counted, so the session can still be used.
+	if w := do(h, http.MethodPost, sessionPath+id+"/encrypt/stream", []byte("Ah-nold")); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestEncryptStream_CleanUpDuringStream(t *testing.T) {
+	db := datastore.NewInMemory(time.Millisecond * 5)
+	t.Cleanup(db.Close)
+	h := newTestHandlersWithDB(t, db, nil, Options{})
+	id := newSession(t, h, map[string]any{"algorithm": "aes256-gcm", "max_bytes": 1 << 30})
+
+	body, in := io.Pipe()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := newRequest(http.MethodPost, sessionPath+id+"/encrypt/stream", []byte{})
+		req.Body, req.ContentLength = body, -1
+		done <- serve(h, req)
+	}()
+
+	// Once the handler has read some of the body it holds its reservation,
+	// which must not exhaust the session whilst the clean up runs.
+	in.Write([]byte("Ah-nold"))
+	time.Sleep(time.Millisecond * 50)
+	if w := do(h, http.MethodGet, sessionPath+id, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected the session to survive clean up, got %d: %s", w.Code, w.Body.String())
+	}
+
+	in.Write([]byte(" Schwarzenegger"))
+	in.Close()
+	if w := <-done; w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var info SessionInfoResponse
+	decode(t, do(h, http.MethodGet, sessionPath+id, nil), &info)
+	if info.UsageCount != 1 || info.BytesProcessed != 22 {
+		t.Errorf("expected usage count of 1 and 22 bytes processed, got %d and %d",
+			info.UsageCount,
+			info.BytesProcessed)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("atostechtest/internal/api")
+
+// tracing is middleware which starts a server span for each request,
+// continuing any trace propagated by the caller, and puts it on the request
+// context so that the session store and datastore spans are its children.
+// Spans are named after the pattern of the route matched.
+func tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		if reqID := middleware.GetReqID(ctx); reqID != "" {
+			span.SetAttributes(attribute.String("http.request_id", reqID))
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK // Nothing was written.
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("status %d", status))
+		}
+	})
+}
+
+// bind decodes and validates a request body, as render.Bind, within a span.
+func bind(r *http.Request, v render.Binder) error {
+	_, span := tracer.Start(r.Context(), "api.bind")
+	defer span.End()
+
+	err := render.Bind(r, v)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
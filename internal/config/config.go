@@ -0,0 +1,379 @@
+// Package config provides the runtime configuration of the service. Each
+// setting may be given, in increasing order of precedence, as a default, in a
+// YAML config file, in an environment variable or as a command line flag.
+package config
+
+import (
+	"atostechtest/internal/sessionstore"
+	"atostechtest/internal/tracing"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileEnv is the environment variable naming the config file when the
+// -config flag is not given.
+const configFileEnv = "CONFIG_FILE"
+
+// ErrInvalidConfig is returned, wrapping the details, when a configuration
+// cannot be loaded or fails validation.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Config holds all runtime settings.
+type Config struct {
+	Port                    int           `yaml:"port"`
+	GracefulShutdownTimeout time.Duration `yaml:"graceful_shutdown_timeout"`
+	ShutdownDrainDelay      time.Duration `yaml:"shutdown_drain_delay"` // Time spent not ready before shutting down.
+	MaxSessionAge           time.Duration `yaml:"max_session_age"`      // The default, and maximum, session TTL.
+	ExpiryPollInterval      time.Duration `yaml:"expiry_poll_interval"` // How often expired sessions are cleaned up.
+
+	Datastore string `yaml:"datastore"` // One of memory, bolt or redis.
+	BoltPath  string `yaml:"bolt_path"`
+	RedisURL  string `yaml:"redis_url"`
+
+	// The master key is given either in a file or base64 or hex encoded,
+	// as are any previous master keys.
+	MasterKeyFile          string   `yaml:"master_key_file"`
+	MasterKey              string   `yaml:"master_key"`
+	PreviousMasterKeyFiles []string `yaml:"previous_master_key_files"`
+	PreviousMasterKeys     []string `yaml:"previous_master_keys"`
+	APIKeysFile            string   `yaml:"api_keys_file"`
+
+	TLS TLS `yaml:"tls"`
+
+	// Token bucket rate limits per client, in requests per second; zero
+	// disables a limit.
+	RateLimit                   float64 `yaml:"rate_limit"`
+	RateLimitBurst              int     `yaml:"rate_limit_burst"`
+	SessionCreateRateLimit      float64 `yaml:"session_create_rate_limit"`
+	SessionCreateRateLimitBurst int     `yaml:"session_create_rate_limit_burst"`
+	AuthFailureRateLimit        float64 `yaml:"auth_failure_rate_limit"` // Per IP address.
+	AuthFailureRateLimitBurst   int     `yaml:"auth_failure_rate_limit_burst"`
+
+	MaxBatchSize int `yaml:"max_batch_size"` // Maximum items in a batch encrypt or decrypt request.
+
+	TracingExporter string `yaml:"tracing_exporter"` // One of the registered trace exporters.
+}
+
+// TLS holds the TLS listener settings. TLS is disabled unless CertFile is set.
+type TLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"` // Enables mutual TLS.
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Port:                    8081,
+		GracefulShutdownTimeout: time.Second * 20,
+		ShutdownDrainDelay:      time.Second * 5,
+		MaxSessionAge:           time.Minute * 10,
+		ExpiryPollInterval:      time.Second * 60,
+		Datastore:               "memory",
+		BoltPath:                "sessions.db",
+		RedisURL:                "redis://localhost:6379/0",
+		TracingExporter:         "none",
+		MaxBatchSize:            1000,
+
+		RateLimitBurst:              100,
+		SessionCreateRateLimitBurst: 20,
+		AuthFailureRateLimit:        1,
+		AuthFailureRateLimitBurst:   10,
+	}
+}
+
+// option describes a setting which can be given as a flag or environment
+// variable. The environment variable name is the flag name upper cased with
+// hyphens replaced by underscores.
+type option struct {
+	name  string
+	usage string
+	value func(c *Config) any // Returns a pointer to the setting.
+}
+
+var options = []option{
+	{"port", "port to listen on",
+		func(c *Config) any { return &c.Port }},
+	{"graceful-shutdown-timeout", "time allowed for in flight requests to complete on shutdown",
+		func(c *Config) any { return &c.GracefulShutdownTimeout }},
+	{"shutdown-drain-delay", "time to report not ready on shutdown, so load balancers stop sending requests, before the server stops accepting them",
+		func(c *Config) any { return &c.ShutdownDrainDelay }},
+	{"max-session-age", "default, and maximum, session lifetime",
+		func(c *Config) any { return &c.MaxSessionAge }},
+	{"expiry-poll-interval", "how often expired sessions are cleaned up (memory and bolt datastores)",
+		func(c *Config) any { return &c.ExpiryPollInterval }},
+	{"datastore", "session datastore backend: memory, bolt or redis",
+		func(c *Config) any { return &c.Datastore }},
+	{"bolt-path", "path of the bolt database file when datastore is bolt",
+		func(c *Config) any { return &c.BoltPath }},
+	{"redis-url", "redis server URL when datastore is redis",
+		func(c *Config) any { return &c.RedisURL }},
+	{"master-key-file", "file holding the base64 or hex encoded master key used to wrap session keys at rest",
+		func(c *Config) any { return &c.MasterKeyFile }},
+	{"master-key", "base64 or hex encoded master key, instead of master-key-file; best set in the environment, as flags are visible to other local users",
+		func(c *Config) any { return &c.MasterKey }},
+	{"previous-master-key-files", "comma separated files holding previous master keys; existing sessions are rewrapped with the current master key at startup",
+		func(c *Config) any { return &c.PreviousMasterKeyFiles }},
+	{"previous-master-keys", "comma separated base64 or hex encoded previous master keys, instead of previous-master-key-files",
+		func(c *Config) any { return &c.PreviousMasterKeys }},
+	{"api-keys-file", "JSON file of API keys required to use the session endpoints; authentication is disabled if unset",
+		func(c *Config) any { return &c.APIKeysFile }},
+	{"tls-cert-file", "PEM certificate file; serves HTTPS when set along with tls-key-file",
+		func(c *Config) any { return &c.TLS.CertFile }},
+	{"tls-key-file", "PEM private key file for tls-cert-file",
+		func(c *Config) any { return &c.TLS.KeyFile }},
+	{"tls-client-ca-file", "PEM bundle of CAs; when set clients must present a certificate signed by one of them (mutual TLS)",
+		func(c *Config) any { return &c.TLS.ClientCAFile }},
+	{"max-batch-size", "maximum number of items in a batch encrypt or decrypt request",
+		func(c *Config) any { return &c.MaxBatchSize }},
+	{"rate-limit", "requests per second allowed to each client across the session endpoints; 0 disables the limit",
+		func(c *Config) any { return &c.RateLimit }},
+	{"rate-limit-burst", "requests each client may make at once before rate-limit applies",
+		func(c *Config) any { return &c.RateLimitBurst }},
+	{"session-create-rate-limit", "sessions per second each client may create; 0 disables the limit",
+		func(c *Config) any { return &c.SessionCreateRateLimit }},
+	{"session-create-rate-limit-burst", "sessions each client may create at once before session-create-rate-limit applies",
+		func(c *Config) any { return &c.SessionCreateRateLimitBurst }},
+	{"auth-failure-rate-limit", "failed API key authentications per second allowed from each IP address, beyond which every request from it is rejected; 0 disables the limit",
+		func(c *Config) any { return &c.AuthFailureRateLimit }},
+	{"auth-failure-rate-limit-burst", "failed API key authentications each IP address may make at once before auth-failure-rate-limit applies",
+		func(c *Config) any { return &c.AuthFailureRateLimitBurst }},
+	{"tracing-exporter", "where OpenTelemetry trace spans are sent: " + strings.Join(tracing.Exporters(), ", "),
+		func(c *Config) any { return &c.TracingExporter }},
+}
+
+// Load builds the configuration from the defaults, the config file named by
+// the -config flag or CONFIG_FILE environment variable, the environment (read
+// with lookupEnv) and the command line flags in args, in increasing order of
+// precedence. The result is validated. flag.ErrHelp is returned if help was
+// requested.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	defaults := Default()
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML config file (default $"+configFileEnv+")")
+	for _, o := range options {
+		fs.String(o.name, format(o.value(defaults)), o.usage+" ($"+envName(o.name)+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(configFileEnv)
+	}
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range options {
+		if v, ok := lookupEnv(envName(o.name)); ok {
+			if err := set(o.value(c), v); err != nil {
+				return nil, fmt.Errorf("%w: $%s: %v", ErrInvalidConfig, envName(o.name), err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, o := range options {
+			if o.name == f.Name && err == nil {
+				if err = set(o.value(c), f.Value.String()); err != nil {
+					err = fmt.Errorf("%w: -%s: %v", ErrInvalidConfig, f.Name, err)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks every setting and returns all problems found.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		invalid("port must be between 1 and 65535, got %d", c.Port)
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"graceful_shutdown_timeout", c.GracefulShutdownTimeout},
+		{"max_session_age", c.MaxSessionAge},
+		{"expiry_poll_interval", c.ExpiryPollInterval},
+	} {
+		if d.value <= 0 {
+			invalid("%s must be positive, got %v", d.name, d.value)
+		}
+	}
+
+	if c.MaxBatchSize < 1 {
+		invalid("max_batch_size must be at least 1, got %d", c.MaxBatchSize)
+	}
+	if c.ShutdownDrainDelay < 0 {
+		invalid("shutdown_drain_delay must not be negative, got %v", c.ShutdownDrainDelay)
+	}
+
+	for _, l := range []struct {
+		name  string
+		rate  float64
+		burst int
+	}{
+		{"rate_limit", c.RateLimit, c.RateLimitBurst},
+		{"session_create_rate_limit", c.SessionCreateRateLimit, c.SessionCreateRateLimitBurst},
+		{"auth_failure_rate_limit", c.AuthFailureRateLimit, c.AuthFailureRateLimitBurst},
+	} {
+		if l.rate < 0 {
+			invalid("%s must not be negative, got %v", l.name, l.rate)
+		}
+		if l.rate > 0 && l.burst < 1 {
+			invalid("%s_burst must be at least 1, got %d", l.name, l.burst)
+		}
+	}
+
+	switch c.Datastore {
+	case "memory":
+	case "bolt":
+		if c.BoltPath == "" {
+			invalid("bolt_path is required when datastore is bolt")
+		}
+	case "redis":
+		if u, err := url.Parse(c.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			invalid("redis_url must be a redis:// or rediss:// URL, got %q", c.RedisURL)
+		}
+	default:
+		invalid("datastore must be one of memory, bolt or redis, got %q", c.Datastore)
+	}
+
+	if c.MasterKeyFile != "" && c.MasterKey != "" {
+		invalid("master_key_file and master_key cannot both be set")
+	}
+	if len(c.PreviousMasterKeyFiles) > 0 && len(c.PreviousMasterKeys) > 0 {
+		invalid("previous_master_key_files and previous_master_keys cannot both be set")
+	}
+	// The keys themselves are never included in errors.
+	if c.MasterKey != "" {
+		if _, err := sessionstore.ParseMasterKey(c.MasterKey); err != nil {
+			invalid("master_key: %v", err)
+		}
+	}
+	for i, key := range c.PreviousMasterKeys {
+		if _, err := sessionstore.ParseMasterKey(key); err != nil {
+			invalid("previous_master_keys[%d]: %v", i, err)
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		invalid("tls cert_file and key_file must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		invalid("tls client_ca_file requires cert_file and key_file")
+	}
+
+	if !slices.Contains(tracing.Exporters(), c.TracingExporter) {
+		invalid("tracing_exporter must be one of %s, got %q",
+			strings.Join(tracing.Exporters(), ", "), c.TracingExporter)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+// loadFile overlays the settings in a YAML config file. Unknown settings are
+// rejected so that typos do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, path, err)
+	}
+	return nil
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// set parses s into the setting pointed to by v.
+func set(v any, s string) error {
+	switch v := v.(type) {
+	case *string:
+		*v = s
+	case *int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = i
+	case *float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*v = f
+	case *time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*v = d
+	case *[]string:
+		*v = nil
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				*v = append(*v, e)
+			}
+		}
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", v))
+	}
+	return nil
+}
+
+// format returns the flag representation of the setting pointed to by v.
+func format(v any) string {
+	switch v := v.(type) {
+	case *string:
+		return *v
+	case *int:
+		return strconv.Itoa(*v)
+	case *float64:
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	case *time.Duration:
+		return v.String()
+	case *[]string:
+		return strings.Join(*v, ",")
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", v))
+	}
+}
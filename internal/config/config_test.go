@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMasterKey is a hex encoded 32 byte master key.
+var testMasterKey = strings.Repeat("ab", 32)
+
+// env returns a lookupEnv function backed by a map.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("unexpected error writing config file: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	c, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(c, Default()) {
+		t.Errorf("expected default config %+v, got %+v", Default(), c)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 9000
+max_session_age: 30m
+expiry_poll_interval: 5s
+datastore: bolt
+previous_master_key_files: [a.key, b.key]
+tls:
+  cert_file: file.pem
+  key_file: file.key
+`)
+
+	c, err := Load(
+		[]string{"-config", path, "-port", "9002", "-tls-cert-file", "flag.pem"},
+		env(map[string]string{
+			"PORT":            "9001",
+			"MAX_SESSION_AGE": "20m",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Default()
+	want.Port = 9002                          // Flag beats environment and file.
+	want.MaxSessionAge = time.Minute * 20     // Environment beats file.
+	want.ExpiryPollInterval = time.Second * 5 // File beats default.
+	want.Datastore = "bolt"
+	want.PreviousMasterKeyFiles = []string{"a.key", "b.key"}
+	want.TLS = TLS{CertFile: "flag.pem", KeyFile: "file.key"}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("expected config %+v, got %+v", want, c)
+	}
+}
+
+func TestLoad_ConfigFileFromEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "port: 9000\n")
+	c, err := Load(nil, env(map[string]string{"CONFIG_FILE": path}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Port != 9000 {
+		t.Errorf("expected port 9000, got %d", c.Port)
+	}
+}
+
+func TestLoad_ListSetting(t *testing.T) {
+	c, err := Load([]string{"-previous-master-key-files", "a.key, b.key,"}, env(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"a.key", "b.key"}; !reflect.DeepEqual(c.PreviousMasterKeyFiles, want) {
+		t.Errorf("expected %v, got %v", want, c.PreviousMasterKeyFiles)
+	}
+}
+
+func TestLoad_MasterKeysFromEnvironment(t *testing.T) {
+	c, err := Load(nil, env(map[string]string{
+		"MASTER_KEY":           testMasterKey,
+		"PREVIOUS_MASTER_KEYS": testMasterKey + "," + testMasterKey,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.MasterKey != testMasterKey {
+		t.Errorf("expected master key %q, got %q", testMasterKey, c.MasterKey)
+	}
+	if want := []string{testMasterKey, testMasterKey}; !reflect.DeepEqual(c.PreviousMasterKeys, want) {
+		t.Errorf("expected previous master keys %v, got %v", want, c.PreviousMasterKeys)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	testCases := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		wantErr string
+	}{
+		{name: "Bad flag value", args: []string{"-port", "http"}, wantErr: "-port"},
+		{name: "Bad environment value", env: map[string]string{"EXPIRY_POLL_INTERVAL": "soon"}, wantErr: "$EXPIRY_POLL_INTERVAL"},
+		{name: "Unknown file setting", file: "prot: 9000\n", wantErr: "prot"},
+		{name: "Port out of range", args: []string{"-port", "70000"}, wantErr: "port must be between"},
+		{name: "Non positive duration", args: []string{"-max-session-age", "0s"}, wantErr: "max_session_age must be positive"},
+		{name: "Negative drain delay", args: []string{"-shutdown-drain-delay", "-1s"}, wantErr: "shutdown_drain_delay"},
+		{name: "Zero batch size", args: []string{"-max-batch-size", "0"}, wantErr: "max_batch_size"},
+		{name: "Negative rate limit", args: []string{"-rate-limit", "-1"}, wantErr: "rate_limit must not be negative"},
+		{name: "Rate limit without burst", args: []string{"-session-create-rate-limit", "1", "-session-create-rate-limit-burst", "0"}, wantErr: "session_create_rate_limit_burst"},
+		{name: "Unknown datastore", args: []string{"-datastore", "mongo"}, wantErr: "datastore must be one of"},
+		{name: "Bad redis URL", args: []string{"-datastore", "redis", "-redis-url", "localhost:6379"}, wantErr: "redis_url"},
+		{name: "TLS key without certificate", args: []string{"-tls-key-file", "key.pem"}, wantErr: "set together"},
+		{name: "Client CA without TLS", args: []string{"-tls-client-ca-file", "ca.pem"}, wantErr: "client_ca_file requires"},
+		{name: "Master key file and key", args: []string{"-master-key-file", "master.key"}, env: map[string]string{"MASTER_KEY": testMasterKey}, wantErr: "master_key_file and master_key"},
+		{name: "Previous master key files and keys", args: []string{"-previous-master-key-files", "a.key"}, env: map[string]string{"PREVIOUS_MASTER_KEYS": testMasterKey}, wantErr: "previous_master_key_files and previous_master_keys"},
+		{name: "Bad master key", env: map[string]string{"MASTER_KEY": "s3cret"}, wantErr: "master_key"},
+		{name: "Bad previous master key", env: map[string]string{"PREVIOUS_MASTER_KEYS": testMasterKey + ",s3cret"}, wantErr: "previous_master_keys[1]"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tc.file)}, args...)
+			}
+			_, err := Load(args, env(tc.env))
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error mentioning %q, got %v", tc.wantErr, err)
+			}
+			if strings.Contains(err.Error(), "s3cret") {
+				t.Errorf("expected error not to reveal the key, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoad_AllProblemsReported(t *testing.T) {
+	_, err := Load([]string{"-port", "0", "-datastore", "mongo"}, env(nil))
+	if err == nil || !strings.Contains(err.Error(), "port") || !strings.Contains(err.Error(), "datastore") {
+		t.Errorf("expected both problems to be reported, got %v", err)
+	}
+}
+
+func TestLoad_Help(t *testing.T) {
+	if _, err := Load([]string{"-h"}, env(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package datastore
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sessionsBucket is the bbolt bucket holding all sessions, keyed by ID.
+var sessionsBucket = []byte("sessions")
+
+// Bolt is a persistent data store implementation, backed by an embedded bbolt
+// key/value file, that satisfies the DB interface. Unlike InMemory, sessions
+// survive a restart of the process. The zero value is not ready to be used,
+// call the NewBolt() function instead.
+type Bolt struct {
+	db                 *bolt.DB
+	logger             *slog.Logger
+	stopChan           chan bool
+	expiryPollInterval time.Duration
+	heartbeat          *heartbeat
+}
+
+// NewBolt takes the path of a bbolt database file, which is created if it does
+// not exist, and an expiryPollInterval and returns a new instance of Bolt. Only
+// one process may open the file at a time. As with NewInMemory, calling this
+// function starts the session house keeping routine which, every
+// expiryPollInterval, checks for and deletes expired sessions. Calling Close()
+// will shutdown this routine and close the underlying file.
+func NewBolt(path string, expiryPollInterval time.Duration) (*Bolt, error) {
+	logger := slog.Default().With("component", "datastore.Bolt")
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	bs := &Bolt{
+		db:                 db,
+		logger:             logger,
+		stopChan:           make(chan bool),
+		expiryPollInterval: expiryPollInterval,
+		heartbeat:          newHeartbeat(expiryPollInterval),
+	}
+
+	go bs.sessionCleanUpFunc()
+
+	return bs, nil
+}
+
+// ReadSession takes a single session ID and performs a session lookup in the
+// database file. If a session is found with a matching session ID ReadSession
+// returns a pointer to the session object; if no session is found nil is
+// returned. An error is returned if the database cannot be read.
+func (db *Bolt) ReadSession(ctx context.Context, id string) (*Session, error) {
+	var s *Session
+	err := db.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		s = &Session{}
+		return decodeSession(v, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// WriteSession takes a session and creates a new unique session ID for it
+// which it then stores, along with the session, in the database file. The
+// newly created session ID is returned. An error is returned if the database
+// cannot be written.
+func (db *Bolt) WriteSession(ctx context.Context, s *Session) (string, error) {
+	id := uuid.NewString()
+	v, err := encodeSession(s)
+	if err != nil {
+		return "", err
+	}
+
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(id), v)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// UpdateSession applies update to the session with the given ID within a
+// single read-write transaction and stores the result. The updated session is
+// returned, or nil if no session is found.
+func (db *Bolt) UpdateSession(ctx context.Context, id string, update func(s *Session) error) (*Session, error) {
+	var s *Session
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		s = &Session{}
+		if err := decodeSession(v, s); err != nil {
+			return err
+		}
+		if err := update(s); err != nil {
+			return err
+		}
+
+		v, err := encodeSession(s)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), v)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// DeleteSession removes the session with the given ID from the database file.
+// An error is returned if the database cannot be written.
+func (db *Bolt) DeleteSession(ctx context.Context, id string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
+
+// CountSessions returns the number of sessions in the database file. An error
+// is returned if the database cannot be read.
+func (db *Bolt) CountSessions(ctx context.Context) (int, error) {
+	var n int
+	err := db.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(sessionsBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// Ping reads the database file and checks that the session house keeping
+// routine is running, returning ErrHousekeepingStopped if not.
+func (db *Bolt) Ping(ctx context.Context) error {
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(sessionsBucket) == nil {
+			return bolt.ErrBucketNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.heartbeat.check()
+}
+
+// RewrapKeys calls rewrap for every key of every session in the database file,
+// replacing the key with the result if it differs. All updates are made in a
+// single transaction so either every session is rewrapped or none are. The
+// number of sessions updated is returned.
+func (db *Bolt) RewrapKeys(ctx context.Context, rewrap func(algorithm, key string) (string, error)) (int, error) {
+	var updated int
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+
+		// Collect updates first as the bucket must not be modified whilst
+		// iterating over it with ForEach.
+		updates := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			var s Session
+			if err := decodeSession(v, &s); err != nil {
+				return err
+			}
+			changed, err := s.rewrapKeys(rewrap)
+			if err != nil || !changed {
+				return err
+			}
+			if updates[string(k)], err = encodeSession(&s); err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range updates {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		updated = len(updates)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return updated, nil
+}
+
+// Close attempts to gracefully stop the session housekeeping routine and
+// close the database file. Attempting to use the store after this call will
+// result in undefined behaviour.
+func (db *Bolt) Close() {
+	db.stopChan <- true
+	if err := db.db.Close(); err != nil {
+		db.logger.Error("closing database", "err", err)
+		return
+	}
+	db.logger.Info("closed")
+}
+
+func (db *Bolt) sessionCleanUpFunc() {
+	ticker := time.NewTicker(db.expiryPollInterval)
+	defer ticker.Stop()
+	defer db.heartbeat.stop()
+
+loop:
+	for {
+		select {
+		case <-db.stopChan:
+			break loop
+		case <-ticker.C:
+			db.logger.Info("running session cleanup")
+			db.cleanUpExpiredSessions() // Blocking.
+			db.heartbeat.beat()
+		}
+	}
+}
+
+func (db *Bolt) cleanUpExpiredSessions() {
+	startTime := time.Now()
+	_, span := tracer.Start(context.Background(), "datastore.cleanUpExpiredSessions",
+		trace.WithAttributes(attribute.String("datastore.backend", "bolt")))
+
+	var deleted int
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(sessionsBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var s Session
+			if err := decodeSession(v, &s); err != nil {
+				// An unreadable session can never be used, so remove it.
+				db.logger.Warn("deleting corrupt session", "id", string(k), "err", err)
+			} else if !s.Expired(time.Now()) {
+				continue
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			deleted += 1
+		}
+		return nil
+	})
+	cleanupDuration.WithLabelValues("bolt").Observe(time.Since(startTime).Seconds())
+	if err == nil {
+		span.SetAttributes(attribute.Int("datastore.deleted", deleted))
+	}
+	end(span, err)
+	if err != nil {
+		db.logger.Error("clean up failed", "err", err)
+		return
+	}
+
+	cleanupDeletedSessions.WithLabelValues("bolt").Add(float64(deleted))
+	db.logger.Info("clean up completed",
+		"deleted sessions", deleted,
+		"duration (ms)", time.Now().Sub(startTime).Milliseconds())
+}
@@ -0,0 +1,199 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBolt(t *testing.T, path string, expiryPollInterval time.Duration) *Bolt {
+	t.Helper()
+	db, err := NewBolt(path, expiryPollInterval)
+	if err != nil {
+		t.Fatalf("unexpected error opening bolt store: %v", err)
+	}
+	return db
+}
+
+func TestBolt_WriteReadSession(t *testing.T) {
+	db := newTestBolt(t, filepath.Join(t.TempDir(), "sessions.db"), time.Minute)
+
+	algorithm := "AES"
+	key := "secret_key\x00\xff"
+
+	sessionID, err := db.WriteSession(context.Background(), newTestSession(algorithm, key, time.Hour))
+	if err != nil {
+		t.Errorf("unexpected error writing session: %v", err)
+	}
+	if sessionID == "" {
+		t.Error("expected non-empty session ID, got empty string")
+	}
+
+	t.Run("Session found", func(t *testing.T) {
+		session, err := db.ReadSession(context.Background(), sessionID)
+		if err != nil {
+			t.Errorf("unexpected error reading session: %v", err)
+		}
+		if session == nil {
+			t.Fatal("expected session to be found, but it was not found")
+		}
+		if session.AlgorithmName != algorithm || session.Key != key {
+			t.Errorf("expected session with algorithm %q and key %q, got %v", algorithm, key, session)
+		}
+	})
+
+	t.Run("Session does not exist", func(t *testing.T) {
+		session, err := db.ReadSession(context.Background(), "non_existent_session_id")
+		if err != nil {
+			t.Errorf("unexpected error reading session: %v", err)
+		}
+		if session != nil {
+			t.Error("expected session not to be found, but it was found")
+		}
+	})
+
+	db.Close()
+}
+
+func TestBolt_SessionsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+
+	db := newTestBolt(t, path, time.Minute)
+	sessionID, _ := db.WriteSession(context.Background(), newTestSession("AES", "key", time.Hour))
+	db.Close()
+
+	db = newTestBolt(t, path, time.Minute)
+	defer db.Close()
+
+	session, err := db.ReadSession(context.Background(), sessionID)
+	if err != nil {
+		t.Errorf("unexpected error reading session: %v", err)
+	}
+	if session == nil {
+		t.Error("expected session to survive a restart, but it was not found")
+	}
+}
+
+func TestBolt_SessionCleanUp(t *testing.T) {
+	db := newTestBolt(t, filepath.Join(t.TempDir(), "sessions.db"), time.Second)
+
+	sessionID, _ := db.WriteSession(context.Background(), newTestSession("AES", "key", time.Second))
+	time.Sleep(2500 * time.Millisecond)
+
+	// Housekeeping routine should have removed the session by now.
+	session, _ := db.ReadSession(context.Background(), sessionID)
+	if session != nil {
+		t.Error("expected session to be cleaned up, but it still exists")
+	}
+
+	db.Close()
+}
+
+func TestBolt_SessionCleanUp_PendingUsage(t *testing.T) {
+	db := newTestBolt(t, filepath.Join(t.TempDir(), "sessions.db"), time.Minute)
+	defer db.Close()
+
+	id, _ := db.WriteSession(context.Background(), newStreamingSession())
+	db.cleanUpExpiredSessions()
+
+	if s, _ := db.ReadSession(context.Background(), id); s == nil {
+		t.Error("expected session with a stream in progress to survive clean up")
+	}
+}
+
+func TestBolt_RewrapKeys(t *testing.T) {
+	db := newTestBolt(t, filepath.Join(t.TempDir(), "sessions.db"), time.Minute)
+	defer db.Close()
+
+	idA, _ := db.WriteSession(context.Background(), newTestSession("AES", "key-a", time.Hour))
+	idB, _ := db.WriteSession(context.Background(), newTestSession("DES", "key-b", time.Hour))
+
+	updated, err := db.RewrapKeys(context.Background(), func(algorithm, key string) (string, error) {
+		if algorithm == "DES" {
+			return key, nil // Unchanged.
+		}
+		return "wrapped-" + key, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error rewrapping keys: %v", err)
+	}
+	if updated != 1 {
+		t.Errorf("expected 1 session to be updated, got %d", updated)
+	}
+
+	if s, _ := db.ReadSession(context.Background(), idA); s == nil || s.Key != "wrapped-key-a" {
+		t.Errorf("expected key to be rewrapped, got %v", s)
+	}
+	if s, _ := db.ReadSession(context.Background(), idB); s == nil || s.Key != "key-b" {
+		t.Errorf("expected key to be unchanged, got %v", s)
+	}
+}
+
+func TestBolt_UpdateDeleteSession(t *testing.T) {
+	db := newTestBolt(t, filepath.Join(t.TempDir(), "sessions.db"), time.Minute)
+	defer db.Close()
+
+	sessionID, _ := db.WriteSession(context.Background(), newTestSession("AES", "key", time.Hour))
+
+	t.Run("Update", func(t *testing.T) {
+		s, err := db.UpdateSession(context.Background(), sessionID, func(s *Session) error {
+			s.UsageCount++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error updating session: %v", err)
+		}
+		if s == nil || s.UsageCount != 1 {
+			t.Errorf("expected updated session with usage count 1, got %v", s)
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s == nil || s.UsageCount != 1 {
+			t.Errorf("expected stored session with usage count 1, got %v", s)
+		}
+	})
+
+	t.Run("Failed update leaves session unchanged", func(t *testing.T) {
+		_, err := db.UpdateSession(context.Background(), sessionID, func(s *Session) error {
+			s.UsageCount = 100
+			return errors.New("nope")
+		})
+		if err == nil {
+			t.Error("expected update error to be returned")
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s == nil || s.UsageCount != 1 {
+			t.Errorf("expected stored session with usage count 1, got %v", s)
+		}
+	})
+
+	t.Run("Update missing session", func(t *testing.T) {
+		s, err := db.UpdateSession(context.Background(), "non_existent_session_id", func(s *Session) error { return nil })
+		if err != nil || s != nil {
+			t.Errorf("expected nil session and error, got %v, %v", s, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := db.DeleteSession(context.Background(), sessionID); err != nil {
+			t.Fatalf("unexpected error deleting session: %v", err)
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s != nil {
+			t.Error("expected session to be deleted, but it still exists")
+		}
+		if err := db.DeleteSession(context.Background(), sessionID); err != nil {
+			t.Errorf("unexpected error deleting missing session: %v", err)
+		}
+	})
+}
+
+func TestBolt_CountSessions(t *testing.T) {
+	db := newTestBolt(t, filepath.Join(t.TempDir(), "sessions.db"), time.Minute)
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		db.WriteSession(context.Background(), newTestSession("AES", "key", time.Hour))
+	}
+	if n, err := db.CountSessions(context.Background()); err != nil || n != 3 {
+		t.Errorf("expected 3 sessions, got %d (err: %v)", n, err)
+	}
+}
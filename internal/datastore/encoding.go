@@ -0,0 +1,21 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// encodeSession serialises a session for storage. Gob is used rather than
+// JSON as keys are arbitrary bytes which JSON would not preserve.
+func encodeSession(s *Session) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeSession reverses encodeSession.
+func decodeSession(v []byte, s *Session) error {
+	return gob.NewDecoder(bytes.NewReader(v)).Decode(s)
+}
@@ -0,0 +1,37 @@
+package datastore
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat records when a session house keeping routine last ran, so that a
+// routine which has stopped or is stuck can be detected.
+type heartbeat struct {
+	last     atomic.Int64 // Unix nanoseconds, zero once the routine has stopped.
+	interval time.Duration
+}
+
+func newHeartbeat(interval time.Duration) *heartbeat {
+	h := &heartbeat{interval: interval}
+	h.beat()
+	return h
+}
+
+func (h *heartbeat) beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+func (h *heartbeat) stop() {
+	h.last.Store(0)
+}
+
+// check returns ErrHousekeepingStopped if the routine has stopped or has not
+// run for two poll intervals.
+func (h *heartbeat) check() error {
+	last := h.last.Load()
+	if last == 0 || time.Since(time.Unix(0, last)) > 2*h.interval {
+		return ErrHousekeepingStopped
+	}
+	return nil
+}
@@ -1,45 +1,44 @@
 package datastore
 
 import (
+	"context"
 	"sync"
 	"time"
 
 	"log/slog"
 
 	"github.com/google/uuid"
-)
-
-var (
-	// expiryPollInterval is used by the session house keeping routine, if
-	// defines the frequency that the routine should trigger. Not constant to
-	// allow for testing.
-	expiryPollInterval = time.Second * 60
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // InMemory is a volitile in-memory data store implementation that satisfies
 // the DB interface. The zero value is not ready to be used, call the
 // NewInMemory() function instead.
 type InMemory struct {
-	mu            *sync.Mutex
-	data          map[string]*Session
-	logger        *slog.Logger
-	stopChan      chan bool
-	maxSessionAge time.Duration
+	mu                 *sync.Mutex
+	data               map[string]*Session
+	logger             *slog.Logger
+	stopChan           chan bool
+	expiryPollInterval time.Duration
+	heartbeat          *heartbeat
 }
 
-// NewInMemory takes a maxSessionAge and returns a new instance of InMemory.
-// Calling this function also starts the session house keeping routine which
-// periodically checks for and deletes expired sessions. Calling Close() will
-// shutdown this routine and render the returned InMemory object unusable.
-func NewInMemory(maxSessionAge time.Duration) *InMemory {
+// NewInMemory takes an expiryPollInterval and returns a new instance of
+// InMemory. Calling this function also starts the session house keeping
+// routine which, every expiryPollInterval, checks for and deletes expired
+// sessions. Calling Close() will shutdown this routine and render the returned
+// InMemory object unusable.
+func NewInMemory(expiryPollInterval time.Duration) *InMemory {
 	logger := slog.Default().With("component", "datastore.InMemory")
 
 	ims := &InMemory{
-		mu:            &sync.Mutex{},
-		data:          make(map[string]*Session),
-		logger:        logger,
-		maxSessionAge: maxSessionAge,
-		stopChan:      make(chan bool),
+		mu:                 &sync.Mutex{},
+		data:               make(map[string]*Session),
+		logger:             logger,
+		stopChan:           make(chan bool),
+		expiryPollInterval: expiryPollInterval,
+		heartbeat:          newHeartbeat(expiryPollInterval),
 	}
 
 	go ims.sessionCleanUpFunc()
@@ -52,7 +51,7 @@ func NewInMemory(maxSessionAge time.Duration) *InMemory {
 // ReadSession returns a pointer to the session object; if no session is found
 // nil is returned. The error will always be nil in this in-memory
 // implementation.
-func (db *InMemory) ReadSession(id string) (*Session, error) {
+func (db *InMemory) ReadSession(ctx context.Context, id string) (*Session, error) {
 	db.mu.Lock()
 	s, ok := db.data[id]
 	db.mu.Unlock()
@@ -62,23 +61,97 @@ func (db *InMemory) ReadSession(id string) (*Session, error) {
 	return s, nil
 }
 
-// WriteSession takes an algorithm and key and creates a new unique session ID
-// for them which it then stores in the in-memory data store. The newly created
-// session ID is returned. The error will always be nil in this in-memory
-// implementation.
-func (db *InMemory) WriteSession(algorithm, key string) (string, error) {
+// WriteSession takes a session and creates a new unique session ID for it
+// which it then stores, along with a copy of the session, in the in-memory
+// data store. The newly created session ID is returned. The error will always
+// be nil in this in-memory implementation.
+func (db *InMemory) WriteSession(ctx context.Context, s *Session) (string, error) {
 	id := uuid.NewString()
+	stored := *s
 	db.mu.Lock()
-	db.data[id] = &Session{
-		AlgorithmName: algorithm,
-		Key:           key,
-		CreatedAt:     time.Now().UTC(),
-	}
+	db.data[id] = &stored
 	db.mu.Unlock()
 
 	return id, nil
 }
 
+// UpdateSession applies update to a copy of the session with the given ID
+// and, if update succeeds, stores the copy in its place. The updated session
+// is returned, or nil if no session is found.
+func (db *InMemory) UpdateSession(ctx context.Context, id string, update func(s *Session) error) (*Session, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	s, ok := db.data[id]
+	if !ok {
+		return nil, nil
+	}
+
+	// Replace rather than mutate as readers may hold the old pointer.
+	updated := *s
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	db.data[id] = &updated
+
+	return &updated, nil
+}
+
+// DeleteSession removes the session with the given ID from the in-memory
+// store. The error will always be nil in this in-memory implementation.
+func (db *InMemory) DeleteSession(ctx context.Context, id string) error {
+	db.mu.Lock()
+	delete(db.data, id)
+	db.mu.Unlock()
+
+	return nil
+}
+
+// CountSessions returns the number of sessions held in memory. The error will
+// always be nil in this in-memory implementation.
+func (db *InMemory) CountSessions(ctx context.Context) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return len(db.data), nil
+}
+
+// Ping checks that the in-memory store can be locked and that the session
+// house keeping routine is running, returning ErrHousekeepingStopped if not.
+func (db *InMemory) Ping(ctx context.Context) error {
+	db.mu.Lock()
+	db.mu.Unlock()
+
+	return db.heartbeat.check()
+}
+
+// RewrapKeys calls rewrap for every key of every session held in memory,
+// replacing the key with the result if it differs. The number of sessions
+// updated is returned. If rewrap returns an error no further sessions are
+// visited.
+func (db *InMemory) RewrapKeys(ctx context.Context, rewrap func(algorithm, key string) (string, error)) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var updated int
+	for id, s := range db.data {
+		// Replace rather than mutate as readers may hold the old pointer.
+		rewrapped := *s
+		changed, err := rewrapped.rewrapKeys(rewrap)
+		if err != nil {
+			return updated, err
+		}
+		if !changed {
+			continue
+		}
+
+		db.data[id] = &rewrapped
+		updated += 1
+	}
+
+	return updated, nil
+}
+
 // Close attempts to gracefully stop the session housekeeping routine and clear
 // down the in-memory store. Attempting to use the in-memory store after this
 // call will result in undefined behaviour.
@@ -89,14 +162,17 @@ func (db *InMemory) Close() {
 }
 
 func (db *InMemory) sessionCleanUpFunc() {
+	defer db.heartbeat.stop()
+
 loop:
 	for {
 		select {
 		case <-db.stopChan:
 			break loop
-		case <-time.NewTicker(expiryPollInterval).C:
+		case <-time.NewTicker(db.expiryPollInterval).C:
 			db.logger.Info("running session cleanup")
 			db.cleanUpExpiredSessions() // Blocking.
+			db.heartbeat.beat()
 		}
 	}
 
@@ -104,17 +180,23 @@ loop:
 
 func (db *InMemory) cleanUpExpiredSessions() {
 	startTime := time.Now()
+	_, span := tracer.Start(context.Background(), "datastore.cleanUpExpiredSessions",
+		trace.WithAttributes(attribute.String("datastore.backend", "memory")))
+	defer span.End()
 
 	var deleted int
 	db.mu.Lock()
 	for k, v := range db.data {
-		if v != nil && time.Now().Sub(v.CreatedAt) > db.maxSessionAge {
+		if v != nil && v.Expired(time.Now()) {
 			delete(db.data, k)
 			deleted += 1
 		}
 	}
 	db.mu.Unlock()
 
+	cleanupDuration.WithLabelValues("memory").Observe(time.Since(startTime).Seconds())
+	cleanupDeletedSessions.WithLabelValues("memory").Add(float64(deleted))
+	span.SetAttributes(attribute.Int("datastore.deleted", deleted))
 	db.logger.Info("clean up completed",
 		"deleted sessions", deleted,
 		"duration (ms)", time.Now().Sub(startTime).Milliseconds())
@@ -1,17 +1,58 @@
 package datastore
 
 import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
 
+// newTestSession returns a session which expires ttl from now.
+func newTestSession(algorithm, key string, ttl time.Duration) *Session {
+	now := time.Now().UTC()
+	return &Session{
+		AlgorithmName: algorithm,
+		Key:           key,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
+		TTL:           ttl,
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		session Session
+		want    bool
+	}{
+		{"Unexpired", Session{ExpiresAt: now.Add(time.Minute)}, false},
+		{"Past expiry", Session{ExpiresAt: now.Add(-time.Minute)}, true},
+		{"Operations remaining", Session{ExpiresAt: now.Add(time.Minute), MaxOperations: 2, UsageCount: 1}, false},
+		{"Operations exhausted", Session{ExpiresAt: now.Add(time.Minute), MaxOperations: 2, UsageCount: 2}, true},
+		{"Bytes remaining", Session{ExpiresAt: now.Add(time.Minute), MaxBytes: 10, BytesProcessed: 9}, false},
+		{"Bytes exhausted", Session{ExpiresAt: now.Add(time.Minute), MaxBytes: 10, BytesProcessed: 12}, true},
+		{"Operations reserved", Session{ExpiresAt: now.Add(time.Minute), MaxOperations: 1, PendingOperations: 1}, false},
+		{"Bytes reserved", Session{ExpiresAt: now.Add(time.Minute), MaxBytes: 10, BytesProcessed: 1, PendingBytes: 9}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.session.Expired(now); got != tt.want {
+				t.Errorf("expected Expired() to return %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
 func TestWriteSession(t *testing.T) {
-	db := NewInMemory(time.Hour)
+	db := NewInMemory(time.Minute)
 
 	algorithm := "AES"
 	key := "secret_key"
 
-	sessionID, err := db.WriteSession(algorithm, key)
+	sessionID, err := db.WriteSession(context.Background(), newTestSession(algorithm, key, time.Hour))
 	if err != nil {
 		t.Errorf("unexpected error writing session: %v", err)
 	}
@@ -20,7 +61,7 @@ func TestWriteSession(t *testing.T) {
 		t.Error("expected non-empty session ID, got empty string")
 	}
 
-	session, _ := db.ReadSession(sessionID)
+	session, _ := db.ReadSession(context.Background(), sessionID)
 	if session == nil {
 		t.Error("expected session to exist in memory, but it was not found")
 	}
@@ -32,15 +73,15 @@ func TestWriteSession(t *testing.T) {
 }
 
 func TestReadSession(t *testing.T) {
-	db := NewInMemory(time.Hour)
+	db := NewInMemory(time.Minute)
 
 	algorithm := "AES"
 	key := "secret_key"
 
-	sessionID, _ := db.WriteSession(algorithm, key)
+	sessionID, _ := db.WriteSession(context.Background(), newTestSession(algorithm, key, time.Hour))
 
 	t.Run("Session found", func(t *testing.T) {
-		session, err := db.ReadSession(sessionID)
+		session, err := db.ReadSession(context.Background(), sessionID)
 		if err != nil {
 			t.Errorf("unexpected error reading session: %v", err)
 		}
@@ -54,7 +95,7 @@ func TestReadSession(t *testing.T) {
 
 	t.Run("Session does not exist", func(t *testing.T) {
 		nonExistentID := "non_existent_session_id"
-		session, err := db.ReadSession(nonExistentID)
+		session, err := db.ReadSession(context.Background(), nonExistentID)
 		if err != nil {
 			t.Errorf("unexpected error reading session: %v", err)
 		}
@@ -68,17 +109,190 @@ func TestReadSession(t *testing.T) {
 
 // Using timers in my tests, yeah I'm not a big fan but here we go!:
 func TestSessionCleanUp(t *testing.T) {
-	expiryPollInterval = time.Second // Set expiry poll interval to 1 second
-	db := NewInMemory(time.Second)   // Set max session age to 1 second
+	db := NewInMemory(time.Second) // Set expiry poll interval to 1 second
 
-	sessionID, _ := db.WriteSession("AES", "key")
+	sessionID, _ := db.WriteSession(context.Background(), newTestSession("AES", "key", time.Second))
+	exhausted := newTestSession("AES", "key", time.Hour)
+	exhausted.MaxOperations = 1
+	exhausted.UsageCount = 1
+	exhaustedID, _ := db.WriteSession(context.Background(), exhausted)
 	time.Sleep(2 * time.Second)
 
-	// Housekeeping routine should have removed the session by now.
-	session, _ := db.ReadSession(sessionID)
+	// Housekeeping routine should have removed the sessions by now.
+	session, _ := db.ReadSession(context.Background(), sessionID)
 	if session != nil {
 		t.Error("expected session to be cleaned up, but it still exists")
 	}
+	session, _ = db.ReadSession(context.Background(), exhaustedID)
+	if session != nil {
+		t.Error("expected exhausted session to be cleaned up, but it still exists")
+	}
+
+	db.Close()
+}
+
+// newStreamingSession returns a session with a stream in progress, holding
+// every byte the session has left.
+func newStreamingSession() *Session {
+	s := newTestSession("AES", "key", time.Hour)
+	s.MaxBytes = 1 << 30
+	s.PendingOperations = 1
+	s.PendingBytes = s.MaxBytes
+	return s
+}
+
+func TestSessionCleanUp_PendingUsage(t *testing.T) {
+	db := NewInMemory(time.Minute)
+	defer db.Close()
+
+	id, _ := db.WriteSession(context.Background(), newStreamingSession())
+	db.cleanUpExpiredSessions()
+
+	if s, _ := db.ReadSession(context.Background(), id); s == nil {
+		t.Error("expected session with a stream in progress to survive clean up")
+	}
+}
+
+func TestRewrapKeys(t *testing.T) {
+	db := NewInMemory(time.Minute)
+	defer db.Close()
+
+	idA, _ := db.WriteSession(context.Background(), newTestSession("AES", "key-a", time.Hour))
+	idB, _ := db.WriteSession(context.Background(), newTestSession("DES", "key-b", time.Hour))
+
+	updated, err := db.RewrapKeys(context.Background(), func(algorithm, key string) (string, error) {
+		if algorithm == "DES" {
+			return key, nil // Unchanged.
+		}
+		return "wrapped-" + key, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error rewrapping keys: %v", err)
+	}
+	if updated != 1 {
+		t.Errorf("expected 1 session to be updated, got %d", updated)
+	}
+
+	if s, _ := db.ReadSession(context.Background(), idA); s == nil || s.Key != "wrapped-key-a" {
+		t.Errorf("expected key to be rewrapped, got %v", s)
+	}
+	if s, _ := db.ReadSession(context.Background(), idB); s == nil || s.Key != "key-b" {
+		t.Errorf("expected key to be unchanged, got %v", s)
+	}
+}
+
+func TestRewrapKeys_SecondaryKeys(t *testing.T) {
+	db := NewInMemory(time.Minute)
+	defer db.Close()
+
+	session := newTestSession("AES", "wrapped-key-a", time.Hour)
+	session.SecondaryKeys = []string{"wrapped-key-b", "key-c"}
+	id, _ := db.WriteSession(context.Background(), session)
+	before, _ := db.ReadSession(context.Background(), id)
+
+	updated, err := db.RewrapKeys(context.Background(), func(algorithm, key string) (string, error) {
+		if strings.HasPrefix(key, "wrapped-") {
+			return key, nil // Unchanged.
+		}
+		return "wrapped-" + key, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error rewrapping keys: %v", err)
+	}
+	if updated != 1 {
+		t.Errorf("expected 1 session to be updated, got %d", updated)
+	}
+
+	s, _ := db.ReadSession(context.Background(), id)
+	if s == nil || !slices.Equal(s.SecondaryKeys, []string{"wrapped-key-b", "wrapped-key-c"}) {
+		t.Errorf("expected secondary keys to be rewrapped, got %v", s)
+	}
+	if before.SecondaryKeys[1] != "key-c" {
+		t.Errorf("expected the previous copy of the session to be unchanged, got %v", before)
+	}
+}
+
+func TestUpdateDeleteSession(t *testing.T) {
+	db := NewInMemory(time.Minute)
+	defer db.Close()
+
+	sessionID, _ := db.WriteSession(context.Background(), newTestSession("AES", "key", time.Hour))
+
+	t.Run("Update", func(t *testing.T) {
+		s, err := db.UpdateSession(context.Background(), sessionID, func(s *Session) error {
+			s.UsageCount++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error updating session: %v", err)
+		}
+		if s == nil || s.UsageCount != 1 {
+			t.Errorf("expected updated session with usage count 1, got %v", s)
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s == nil || s.UsageCount != 1 {
+			t.Errorf("expected stored session with usage count 1, got %v", s)
+		}
+	})
+
+	t.Run("Failed update leaves session unchanged", func(t *testing.T) {
+		_, err := db.UpdateSession(context.Background(), sessionID, func(s *Session) error {
+			s.UsageCount = 100
+			return errors.New("nope")
+		})
+		if err == nil {
+			t.Error("expected update error to be returned")
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s == nil || s.UsageCount != 1 {
+			t.Errorf("expected stored session with usage count 1, got %v", s)
+		}
+	})
+
+	t.Run("Update missing session", func(t *testing.T) {
+		s, err := db.UpdateSession(context.Background(), "non_existent_session_id", func(s *Session) error { return nil })
+		if err != nil || s != nil {
+			t.Errorf("expected nil session and error, got %v, %v", s, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := db.DeleteSession(context.Background(), sessionID); err != nil {
+			t.Fatalf("unexpected error deleting session: %v", err)
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s != nil {
+			t.Error("expected session to be deleted, but it still exists")
+		}
+		if err := db.DeleteSession(context.Background(), sessionID); err != nil {
+			t.Errorf("unexpected error deleting missing session: %v", err)
+		}
+	})
+}
+
+func TestCountSessions(t *testing.T) {
+	db := NewInMemory(time.Minute)
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		db.WriteSession(context.Background(), newTestSession("AES", "key", time.Hour))
+	}
+	if n, err := db.CountSessions(context.Background()); err != nil || n != 3 {
+		t.Errorf("expected 3 sessions, got %d (err: %v)", n, err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	db := NewInMemory(time.Second)
+
+	if err := db.Ping(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if err := db.Ping(context.Background()); err != nil {
+		t.Errorf("unexpected error after a clean up run: %v", err)
+	}
 
 	db.Close()
+	time.Sleep(10 * time.Millisecond) // Let the house keeping routine exit.
+	if err := db.Ping(context.Background()); !errors.Is(err, ErrHousekeepingStopped) {
+		t.Errorf("expected ErrHousekeepingStopped once closed, got %v", err)
+	}
 }
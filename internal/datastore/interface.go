@@ -3,19 +3,116 @@
 package datastore
 
 import (
+	"context"
+	"errors"
 	"time"
 )
 
+// ErrHousekeepingStopped is returned by Ping when the routine which cleans up
+// expired sessions is no longer running.
+var ErrHousekeepingStopped = errors.New("session clean up routine is not running")
+
 // Session encapsulates a session object at the data layer.
 type Session struct {
+	Owner         string // The principal which created the session.
 	AlgorithmName string
-	Key           string
-	CreatedAt     time.Time
+	Key           string   // The primary key, used to encrypt.
+	SecondaryKeys []string // Further keys, which can only decrypt.
+
+	// The hex encoded IDs of the primary and secondary keys. Sessions
+	// written before key IDs were stored have none.
+	KeyID           string
+	SecondaryKeyIDs []string
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	TTL       time.Duration // Session lifetime, used to slide ExpiresAt on refresh.
+
+	// Usage limits, zero meaning unlimited, and the usage counted against
+	// them.
+	MaxOperations  int64
+	MaxBytes       int64
+	UsageCount     int64 // Number of encrypt and decrypt operations.
+	BytesProcessed int64 // Number of input bytes encrypted or decrypted.
+
+	// Usage reserved by requests still in progress. It counts against the
+	// limits of further reservations but does not exhaust the session.
+	PendingOperations int64
+	PendingBytes      int64
+}
+
+// Exhausted returns true if the session has reached any of its usage limits.
+// Pending usage is not counted, so a session is not removed while requests
+// which may yet give their reservations back are in progress.
+func (s *Session) Exhausted() bool {
+	return (s.MaxOperations > 0 && s.UsageCount >= s.MaxOperations) ||
+		(s.MaxBytes > 0 && s.BytesProcessed >= s.MaxBytes)
+}
+
+// Expired returns true if the session has passed its expiry time at now or
+// has been exhausted, and so can no longer be used.
+func (s *Session) Expired(now time.Time) bool {
+	return now.After(s.ExpiresAt) || s.Exhausted()
+}
+
+// rewrapKeys calls rewrap for every key of the session, replacing each key
+// with the result, and returns true if any key changed. The secondary keys are
+// copied rather than modified in place as readers may share them.
+func (s *Session) rewrapKeys(rewrap func(algorithm, key string) (string, error)) (bool, error) {
+	key, err := rewrap(s.AlgorithmName, s.Key)
+	if err != nil {
+		return false, err
+	}
+	changed := key != s.Key
+	s.Key = key
+
+	if len(s.SecondaryKeys) == 0 {
+		return changed, nil
+	}
+	secondary := make([]string, len(s.SecondaryKeys))
+	for i, k := range s.SecondaryKeys {
+		if secondary[i], err = rewrap(s.AlgorithmName, k); err != nil {
+			return false, err
+		}
+		changed = changed || secondary[i] != k
+	}
+	s.SecondaryKeys = secondary
+
+	return changed, nil
 }
 
 // DB is the core datastore interface. All implementations herein should
-// conform to this interface.
+// conform to this interface. Every method takes the context of the request it
+// is made for, which carries any trace span and deadline.
 type DB interface {
-	ReadSession(id string) (*Session, error)
-	WriteSession(algorithm, key string) (string, error)
+	ReadSession(ctx context.Context, id string) (*Session, error)
+
+	// WriteSession stores a new session, which should have its expiry set,
+	// under a newly created unique ID which is returned.
+	WriteSession(ctx context.Context, s *Session) (string, error)
+
+	// UpdateSession atomically applies update to the session with the given
+	// ID and stores the result, which is also returned. If the session does
+	// not exist nil is returned. If update returns an error the session is
+	// left unchanged and the error is returned.
+	UpdateSession(ctx context.Context, id string, update func(s *Session) error) (*Session, error)
+
+	// DeleteSession removes the session with the given ID. Deleting a
+	// session which does not exist is not an error.
+	DeleteSession(ctx context.Context, id string) error
+
+	// CountSessions returns the number of stored sessions. Expired sessions
+	// may be included until they are cleaned up.
+	CountSessions(ctx context.Context) (int, error)
+
+	// Ping makes a round trip to the datastore and checks that its session
+	// house keeping routine, if it has one, is running. It returns an error
+	// if the datastore is not able to serve requests.
+	Ping(ctx context.Context) error
+
+	// RewrapKeys calls rewrap with the algorithm and each key of every
+	// stored session and replaces the key with the result if it differs. It
+	// is used to re-encrypt session keys after a master key rotation and
+	// returns the number of sessions updated.
+	RewrapKeys(ctx context.Context, rewrap func(algorithm, key string) (string, error)) (int, error)
 }
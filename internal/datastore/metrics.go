@@ -0,0 +1,46 @@
+package datastore
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cleanupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "atostechtest",
+		Subsystem: "datastore",
+		Name:      "cleanup_duration_seconds",
+		Help:      "Duration of expired session clean up runs.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10},
+	}, []string{"backend"})
+
+	cleanupDeletedSessions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "atostechtest",
+		Subsystem: "datastore",
+		Name:      "cleanup_deleted_sessions_total",
+		Help:      "Number of expired sessions deleted by clean up runs.",
+	}, []string{"backend"})
+)
+
+// NewActiveSessionsCollector returns a gauge reporting the number of sessions
+// stored in db each time metrics are collected. It is not registered; register
+// it once with the Prometheus registry in use. A gauge value of -1 is reported
+// if the sessions cannot be counted.
+func NewActiveSessionsCollector(db DB) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "atostechtest",
+		Subsystem: "datastore",
+		Name:      "active_sessions",
+		Help:      "Number of sessions currently stored, including expired sessions not yet cleaned up.",
+	}, func() float64 {
+		n, err := db.CountSessions(context.Background())
+		if err != nil {
+			slog.Default().With("component", "datastore").Error("counting sessions", "err", err)
+			return -1
+		}
+		return float64(n)
+	})
+}
@@ -0,0 +1,245 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	db, err := NewRedis("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error connecting to redis: %v", err)
+	}
+	return db, mr
+}
+
+func TestRedis_WriteReadSession(t *testing.T) {
+	db, mr := newTestRedis(t)
+	defer db.Close()
+
+	algorithm := "AES"
+	key := "secret_key\x00\xff"
+
+	sessionID, err := db.WriteSession(context.Background(), newTestSession(algorithm, key, time.Hour))
+	if err != nil {
+		t.Errorf("unexpected error writing session: %v", err)
+	}
+	if sessionID == "" {
+		t.Error("expected non-empty session ID, got empty string")
+	}
+
+	t.Run("Session found", func(t *testing.T) {
+		session, err := db.ReadSession(context.Background(), sessionID)
+		if err != nil {
+			t.Errorf("unexpected error reading session: %v", err)
+		}
+		if session == nil {
+			t.Fatal("expected session to be found, but it was not found")
+		}
+		if session.AlgorithmName != algorithm || session.Key != key {
+			t.Errorf("expected session with algorithm %q and key %q, got %v", algorithm, key, session)
+		}
+	})
+
+	t.Run("Session has TTL", func(t *testing.T) {
+		if ttl := mr.TTL(redisKeyPrefix + sessionID); ttl < 59*time.Minute || ttl > time.Hour {
+			t.Errorf("expected TTL of about %v, got %v", time.Hour, ttl)
+		}
+	})
+
+	t.Run("Session does not exist", func(t *testing.T) {
+		session, err := db.ReadSession(context.Background(), "non_existent_session_id")
+		if err != nil {
+			t.Errorf("unexpected error reading session: %v", err)
+		}
+		if session != nil {
+			t.Error("expected session not to be found, but it was found")
+		}
+	})
+}
+
+func TestRedis_SessionExpiry(t *testing.T) {
+	db, mr := newTestRedis(t)
+	defer db.Close()
+
+	sessionID, _ := db.WriteSession(context.Background(), newTestSession("AES", "key", time.Minute))
+	mr.FastForward(2 * time.Minute)
+
+	session, err := db.ReadSession(context.Background(), sessionID)
+	if err != nil {
+		t.Errorf("unexpected error reading session: %v", err)
+	}
+	if session != nil {
+		t.Error("expected session to have expired, but it still exists")
+	}
+}
+
+func TestRedis_Unreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	if _, err := NewRedis("redis://" + addr); err == nil {
+		t.Error("expected an error connecting to a stopped server")
+	}
+}
+
+func TestRedis_RewrapKeys(t *testing.T) {
+	db, mr := newTestRedis(t)
+	defer db.Close()
+
+	idA, _ := db.WriteSession(context.Background(), newTestSession("AES", "key-a", time.Hour))
+	idB, _ := db.WriteSession(context.Background(), newTestSession("DES", "key-b", time.Hour))
+
+	updated, err := db.RewrapKeys(context.Background(), func(algorithm, key string) (string, error) {
+		if algorithm == "DES" {
+			return key, nil // Unchanged.
+		}
+		return "wrapped-" + key, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error rewrapping keys: %v", err)
+	}
+	if updated != 1 {
+		t.Errorf("expected 1 session to be updated, got %d", updated)
+	}
+
+	if s, _ := db.ReadSession(context.Background(), idA); s == nil || s.Key != "wrapped-key-a" {
+		t.Errorf("expected key to be rewrapped, got %v", s)
+	}
+	if s, _ := db.ReadSession(context.Background(), idB); s == nil || s.Key != "key-b" {
+		t.Errorf("expected key to be unchanged, got %v", s)
+	}
+
+	if ttl := mr.TTL(redisKeyPrefix + idA); ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("expected rewrap to preserve TTL of about %v, got %v", time.Hour, ttl)
+	}
+}
+
+func TestRedis_RewrapKeys_SecondaryKeys(t *testing.T) {
+	db, _ := newTestRedis(t)
+	defer db.Close()
+
+	session := newTestSession("AES", "wrapped-key-a", time.Hour)
+	session.SecondaryKeys = []string{"key-b"}
+	id, _ := db.WriteSession(context.Background(), session)
+
+	updated, err := db.RewrapKeys(context.Background(), func(algorithm, key string) (string, error) {
+		if strings.HasPrefix(key, "wrapped-") {
+			return key, nil // Unchanged.
+		}
+		return "wrapped-" + key, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error rewrapping keys: %v", err)
+	}
+	if updated != 1 {
+		t.Errorf("expected 1 session to be updated, got %d", updated)
+	}
+
+	s, _ := db.ReadSession(context.Background(), id)
+	if s == nil || s.Key != "wrapped-key-a" || !slices.Equal(s.SecondaryKeys, []string{"wrapped-key-b"}) {
+		t.Errorf("expected secondary key to be rewrapped, got %v", s)
+	}
+}
+
+func TestRedis_UpdateDeleteSession(t *testing.T) {
+	db, mr := newTestRedis(t)
+	defer db.Close()
+
+	sessionID, _ := db.WriteSession(context.Background(), newTestSession("AES", "key", time.Hour))
+
+	t.Run("Update", func(t *testing.T) {
+		s, err := db.UpdateSession(context.Background(), sessionID, func(s *Session) error {
+			s.UsageCount++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error updating session: %v", err)
+		}
+		if s == nil || s.UsageCount != 1 {
+			t.Errorf("expected updated session with usage count 1, got %v", s)
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s == nil || s.UsageCount != 1 {
+			t.Errorf("expected stored session with usage count 1, got %v", s)
+		}
+	})
+
+	t.Run("Failed update leaves session unchanged", func(t *testing.T) {
+		_, err := db.UpdateSession(context.Background(), sessionID, func(s *Session) error {
+			s.UsageCount = 100
+			return errors.New("nope")
+		})
+		if err == nil {
+			t.Error("expected update error to be returned")
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s == nil || s.UsageCount != 1 {
+			t.Errorf("expected stored session with usage count 1, got %v", s)
+		}
+	})
+
+	t.Run("Update missing session", func(t *testing.T) {
+		s, err := db.UpdateSession(context.Background(), "non_existent_session_id", func(s *Session) error { return nil })
+		if err != nil || s != nil {
+			t.Errorf("expected nil session and error, got %v, %v", s, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := db.DeleteSession(context.Background(), sessionID); err != nil {
+			t.Fatalf("unexpected error deleting session: %v", err)
+		}
+		if s, _ := db.ReadSession(context.Background(), sessionID); s != nil {
+			t.Error("expected session to be deleted, but it still exists")
+		}
+		if err := db.DeleteSession(context.Background(), sessionID); err != nil {
+			t.Errorf("unexpected error deleting missing session: %v", err)
+		}
+	})
+
+	t.Run("Refresh extends TTL", func(t *testing.T) {
+		id, _ := db.WriteSession(context.Background(), newTestSession("AES", "key", time.Hour))
+		mr.FastForward(30 * time.Minute)
+		db.UpdateSession(context.Background(), id, func(s *Session) error {
+			s.ExpiresAt = time.Now().Add(s.TTL)
+			return nil
+		})
+		if ttl := mr.TTL(redisKeyPrefix + id); ttl < 59*time.Minute {
+			t.Errorf("expected TTL to be reset to about an hour, got %v", ttl)
+		}
+	})
+}
+
+func TestRedis_CountSessions(t *testing.T) {
+	db, mr := newTestRedis(t)
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		db.WriteSession(context.Background(), newTestSession("AES", "key", time.Hour))
+	}
+	mr.Set("unrelated", "value")
+	if n, err := db.CountSessions(context.Background()); err != nil || n != 3 {
+		t.Errorf("expected 3 sessions, got %d (err: %v)", n, err)
+	}
+}
+
+func TestRedis_Ping(t *testing.T) {
+	db, mr := newTestRedis(t)
+	defer db.Close()
+
+	if err := db.Ping(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	mr.Close()
+	if err := db.Ping(context.Background()); err == nil {
+		t.Error("expected an error when redis is unreachable")
+	}
+}
@@ -0,0 +1,91 @@
+package datastore
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("atostechtest/internal/datastore")
+
+// Traced wraps db so that every call is recorded as a span, a child of any
+// span on the call's context, named after the method and tagged with the
+// backend name (for example memory, bolt or redis).
+func Traced(db DB, backend string) DB {
+	return &tracedDB{db: db, backend: backend}
+}
+
+type tracedDB struct {
+	db      DB
+	backend string
+}
+
+func (t *tracedDB) ReadSession(ctx context.Context, id string) (*Session, error) {
+	ctx, span := t.start(ctx, "ReadSession")
+	s, err := t.db.ReadSession(ctx, id)
+	span.SetAttributes(attribute.Bool("datastore.found", s != nil))
+	end(span, err)
+	return s, err
+}
+
+func (t *tracedDB) WriteSession(ctx context.Context, s *Session) (string, error) {
+	ctx, span := t.start(ctx, "WriteSession")
+	id, err := t.db.WriteSession(ctx, s)
+	end(span, err)
+	return id, err
+}
+
+func (t *tracedDB) UpdateSession(ctx context.Context, id string, update func(s *Session) error) (*Session, error) {
+	ctx, span := t.start(ctx, "UpdateSession")
+	s, err := t.db.UpdateSession(ctx, id, update)
+	span.SetAttributes(attribute.Bool("datastore.found", s != nil))
+	end(span, err)
+	return s, err
+}
+
+func (t *tracedDB) DeleteSession(ctx context.Context, id string) error {
+	ctx, span := t.start(ctx, "DeleteSession")
+	err := t.db.DeleteSession(ctx, id)
+	end(span, err)
+	return err
+}
+
+func (t *tracedDB) CountSessions(ctx context.Context) (int, error) {
+	ctx, span := t.start(ctx, "CountSessions")
+	n, err := t.db.CountSessions(ctx)
+	end(span, err)
+	return n, err
+}
+
+func (t *tracedDB) Ping(ctx context.Context) error {
+	ctx, span := t.start(ctx, "Ping")
+	err := t.db.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+func (t *tracedDB) RewrapKeys(ctx context.Context, rewrap func(algorithm, key string) (string, error)) (int, error) {
+	ctx, span := t.start(ctx, "RewrapKeys")
+	n, err := t.db.RewrapKeys(ctx, rewrap)
+	span.SetAttributes(attribute.Int("datastore.updated", n))
+	end(span, err)
+	return n, err
+}
+
+func (t *tracedDB) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "datastore."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("datastore.backend", t.backend)))
+}
+
+// end records err, if any, on span and ends it.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
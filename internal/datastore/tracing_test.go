@@ -0,0 +1,41 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	mem := NewInMemory(time.Minute)
+	defer mem.Close()
+	db := Traced(mem, "memory")
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	id, _ := db.WriteSession(ctx, newTestSession("AES", "key", time.Hour))
+	if s, _ := db.ReadSession(ctx, id); s == nil {
+		t.Fatal("expected session to be read through the traced datastore")
+	}
+	parent.End()
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		if span.Name() == "request" {
+			continue
+		}
+		names = append(names, span.Name())
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected span %q to be a child of the request span", span.Name())
+		}
+	}
+	if len(names) != 2 || names[0] != "datastore.WriteSession" || names[1] != "datastore.ReadSession" {
+		t.Errorf("expected WriteSession and ReadSession spans, got %v", names)
+	}
+}
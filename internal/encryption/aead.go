@@ -0,0 +1,41 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// aeadCipher implements Cipher for any authenticated encryption with
+// associated data algorithm. Each message is sealed under a fresh random
+// nonce which is prepended to the output; the authentication tag is appended
+// by the underlying cipher.AEAD.
+type aeadCipher struct {
+	aead cipher.AEAD
+}
+
+func (c *aeadCipher) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+
+	nonce := make([]byte, nonceSize, nonceSize+len(plaintext)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Join(ErrGeneratingIV, err)
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *aeadCipher) Open(cipherText, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(cipherText) < nonceSize+c.aead.Overhead() {
+		return nil, ErrInvalidCipherTextBlockSize
+	}
+
+	plaintext, err := c.aead.Open(nil, cipherText[:nonceSize], cipherText[nonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+
+	return plaintext, nil
+}
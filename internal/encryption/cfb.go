@@ -0,0 +1,95 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// Unauthenticated block ciphers operating in CFB mode. Tampering with cipher
+// texts produced by these algorithms goes undetected; prefer one of the AEAD
+// algorithms where possible.
+const (
+	AES128 Algorithm = "aes128"
+	AES192 Algorithm = "aes192"
+	AES256 Algorithm = "aes256"
+	DES    Algorithm = "des"
+)
+
+func init() {
+	newAES := func(key []byte) (Cipher, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return &cfbCipher{block: block}, nil
+	}
+
+	Register(Registration{Name: AES128, ID: 1, KeySizes: []int{16}, NonceSize: aes.BlockSize, New: newAES})
+	Register(Registration{Name: AES192, ID: 2, KeySizes: []int{24}, NonceSize: aes.BlockSize, New: newAES})
+	Register(Registration{Name: AES256, ID: 3, KeySizes: []int{32}, NonceSize: aes.BlockSize, New: newAES})
+	Register(Registration{
+		Name:      DES,
+		ID:        4,
+		KeySizes:  []int{8},
+		NonceSize: des.BlockSize,
+		New: func(key []byte) (Cipher, error) {
+			block, err := des.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			return &cfbCipher{block: block}, nil
+		},
+	})
+}
+
+// cfbCipher implements Cipher for any block cipher using CFB mode with a
+// random IV.
+//
+// **CREDIT** Inspired by the code I saw here:
+//
+//	https://gist.github.com/fracasula/38aa1a4e7481f9cedfa78a0cdd5f1865
+type cfbCipher struct {
+	block cipher.Block
+}
+
+func (c *cfbCipher) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	if len(additionalData) > 0 {
+		return nil, ErrAADNotSupported
+	}
+
+	blockSize := c.block.BlockSize()
+
+	cipherText := make([]byte, blockSize+len(plaintext))
+	iv := cipherText[:blockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, errors.Join(ErrGeneratingIV, err)
+	}
+
+	stream := cipher.NewCFBEncrypter(c.block, iv)
+	stream.XORKeyStream(cipherText[blockSize:], plaintext)
+
+	return cipherText, nil
+}
+
+func (c *cfbCipher) Open(cipherText, additionalData []byte) ([]byte, error) {
+	if len(additionalData) > 0 {
+		return nil, ErrAADNotSupported
+	}
+
+	blockSize := c.block.BlockSize()
+	if len(cipherText) < blockSize {
+		return nil, ErrInvalidCipherTextBlockSize
+	}
+
+	iv := cipherText[:blockSize]
+	plaintext := make([]byte, len(cipherText)-blockSize)
+
+	stream := cipher.NewCFBDecrypter(c.block, iv)
+	stream.XORKeyStream(plaintext, cipherText[blockSize:])
+
+	return plaintext, nil
+}
@@ -0,0 +1,28 @@
+package encryption
+
+import (
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ChaCha20-Poly1305 (RFC 8439) and its extended nonce variant.
+const (
+	ChaCha20Poly1305  Algorithm = "chacha20-poly1305"
+	XChaCha20Poly1305 Algorithm = "xchacha20-poly1305"
+)
+
+func init() {
+	Register(Registration{
+		Name:      ChaCha20Poly1305,
+		ID:        7,
+		KeySizes:  []int{chacha20poly1305.KeySize},
+		NonceSize: chacha20poly1305.NonceSize,
+		NewAEAD:   chacha20poly1305.New,
+	})
+	Register(Registration{
+		Name:      XChaCha20Poly1305,
+		ID:        8,
+		KeySizes:  []int{chacha20poly1305.KeySize},
+		NonceSize: chacha20poly1305.NonceSizeX,
+		NewAEAD:   chacha20poly1305.NewX,
+	})
+}
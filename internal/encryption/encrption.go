@@ -3,18 +3,18 @@
 // easier by providing a simple interface for encrypting and decrypting
 // messages given just an algorithm name, key and body.
 //
+// Algorithms are held in a registry; each one lives in its own file and
+// registers itself from an init function (see Register).
+//
 // It is understood that this wrapper is primitive and rudimentary and should
 // not be used in production, it is simply for illustrative purposes.
 package encryption
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/des"
 	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"io"
+	"slices"
 )
 
 var (
@@ -22,10 +22,6 @@ var (
 	// invalid key is a likely culprit.
 	ErrCipherCreation = errors.New("could not create cipher")
 
-	// ErrBase64DecodeError indicates a problem in base64 decoding an input
-	// text.
-	ErrBase64DecodeError = errors.New("could not base64 decode input")
-
 	// ErrInvalidCipherTextBlockSize indicates a mismatch between the
 	// ciphertext input length and the cipher block size.
 	ErrInvalidCipherTextBlockSize = errors.New("invalid ciphertext block size")
@@ -33,125 +29,103 @@ var (
 	// ErrGeneratingIV indicates an issue during the initialisation vector
 	// creation stage.
 	ErrGeneratingIV = errors.New("could not create IV")
-)
 
-var supportedSymmetricAlgorithms = []string{
-	"aes128",
-	"aes192",
-	"aes256",
-	"des",
-}
+	// ErrAuthenticationFailed indicates that an authenticated cipher text
+	// could not be verified; either the cipher text has been tampered with or
+	// the wrong key was used.
+	ErrAuthenticationFailed = errors.New("message authentication failed")
 
-type Algorithm string
+	// ErrUnsupportedAlgorithm indicates that no algorithm is registered under
+	// the requested name.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+	// ErrAADNotSupported indicates that additional authenticated data was
+	// supplied for an algorithm which cannot authenticate it.
+	ErrAADNotSupported = errors.New("additional authenticated data requires an AEAD algorithm")
 
-const (
-	AES128 Algorithm = "aes128"
-	AES192 Algorithm = "aes192"
-	AES256 Algorithm = "aes256"
-	DES    Algorithm = "des"
+	// ErrGeneratingKey indicates an issue reading random bytes whilst
+	// generating a key.
+	ErrGeneratingKey = errors.New("could not generate key")
 )
 
-// Algorithms returns the list of supported symmetric algorithms.
-func Algorithms() []string {
-	return supportedSymmetricAlgorithms
-}
+// Algorithm is the name under which an algorithm is registered.
+type Algorithm string
 
 // ValidateAlgoKeyPair takes an algorithm and a key and returns true if the key
 // is valid for the given algorithm, false otherwise.
 func ValidateAlgoKeyPair(algo Algorithm, key []byte) bool {
-	switch algo {
-	case AES128:
-		return len(key) == 16
-	case AES192:
-		return len(key) == 24
-	case AES256:
-		return len(key) == 32
-	case DES:
-		return len(key) == 8
-	default:
+	r, ok := Lookup(algo)
+	if !ok {
 		return false
 	}
+	return slices.Contains(r.KeySizes, len(key))
 }
 
-// Encrypt takes an algorithm name, a key and a plaintext and attempts to
-// encrypt the plaintext. If successful the base64 encoded cipher text is
-// returned. Consult the typed errors in this package to understand which
-// errors can occur.
-//
-// **CREDIT** Inspired by the code I saw here:
-//
-//	https://gist.github.com/fracasula/38aa1a4e7481f9cedfa78a0cdd5f1865
-func Encrypt(algo Algorithm, key []byte, plaintext string) (string, error) {
-	var (
-		blockSize      int
-		block          cipher.Block
-		plaintextBytes = []byte(plaintext)
-		err            error
-	)
-	switch algo {
-	case AES128, AES192, AES256:
-		blockSize = aes.BlockSize
-		block, err = aes.NewCipher(key)
-	case DES:
-		blockSize = des.BlockSize
-		block, err = des.NewCipher(key)
-	}
-	if err != nil {
-		return "", errors.Join(ErrCipherCreation, err)
+// GenerateKey returns a new random key, read from crypto/rand, of the correct
+// size for the given algorithm. Where an algorithm accepts several key sizes
+// the largest is used.
+func GenerateKey(algo Algorithm) ([]byte, error) {
+	r, ok := Lookup(algo)
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
 	}
 
-	cipherText := make([]byte, blockSize+len(plaintextBytes))
-	iv := cipherText[:blockSize]
-	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
-		return "", errors.Join(ErrGeneratingIV, err)
+	key := make([]byte, slices.Max(r.KeySizes))
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.Join(ErrGeneratingKey, err)
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(cipherText[blockSize:], plaintextBytes)
-
-	return base64.StdEncoding.EncodeToString(cipherText), nil
+	return key, nil
 }
 
-// Decrypt takes an algorithm name, a key and a cipher text and attempts to
-// decrypt the cipher text. If successful the unencoded plaintext is returned.
-// Consult the typed errors in this package to understand which errors can
-// occur.
-//
-// **CREDIT** Inspired by the code I saw here:
-//
-//	https://gist.github.com/fracasula/38aa1a4e7481f9cedfa78a0cdd5f1865
-func Decrypt(algo Algorithm, key []byte, cipherText string) (string, error) {
-	var (
-		blockSize int
-		block     cipher.Block
-		err       error
-	)
-	switch algo {
-	case AES128, AES192, AES256:
-		blockSize = aes.BlockSize
-		block, err = aes.NewCipher(key)
-	case DES:
-		blockSize = des.BlockSize
-		block, err = des.NewCipher(key)
-	}
+// Encrypt takes an algorithm name, a key and its ID, a plaintext and optional
+// additional authenticated data (AAD) and attempts to encrypt the plaintext.
+// The AAD is not encrypted but is bound to the cipher text; it must be
+// presented again, unchanged, to Decrypt. AAD is only supported by AEAD
+// algorithms. If successful the cipher text is returned in a self-describing
+// envelope which records the algorithm and key ID (see envelope.go); encoding
+// it for transport is left to the caller. Consult the typed errors in this
+// package to understand which errors can occur.
+func Encrypt(algo Algorithm, key []byte, keyID KeyID, plaintext, aad []byte) ([]byte, error) {
+	r, c, err := newCipher(algo, key)
 	if err != nil {
-		return "", errors.Join(ErrCipherCreation, err)
+		return nil, err
 	}
 
-	cipherTextBytes, err := base64.StdEncoding.DecodeString(cipherText)
+	return seal(r, c, keyID, plaintext, aad)
+}
+
+// Decrypt takes an algorithm name, a key and its ID, a cipher text as produced
+// by Encrypt and the additional authenticated data given at encryption time
+// (if any) and attempts to decrypt the cipher text. If successful the
+// plaintext is returned. The envelope header is checked first; a cipher text
+// encrypted with another algorithm or key ID results in ErrAlgorithmMismatch
+// or ErrKeyMismatch respectively. A mismatched AAD results in
+// ErrAuthenticationFailed. Legacy cipher texts, written before the envelope
+// was introduced, are still accepted. Consult the typed errors in this package
+// to understand which errors can occur.
+func Decrypt(algo Algorithm, key []byte, keyID KeyID, cipherText, aad []byte) ([]byte, error) {
+	r, c, err := newCipher(algo, key)
 	if err != nil {
-		return "", errors.Join(ErrBase64DecodeError, err)
+		return nil, err
 	}
 
-	if len(cipherTextBytes) < blockSize {
-		return "", errors.Join(ErrInvalidCipherTextBlockSize, err)
+	if !isEnvelope(cipherText) {
+		plaintext, legacyErr := c.Open(cipherText, aad)
+		if legacyErr != nil && len(cipherText) > 0 && cipherText[0] == envelopeMagic {
+			// Most likely an envelope from a later version of this package.
+			return nil, ErrUnsupportedVersion
+		}
+		return plaintext, legacyErr
 	}
 
-	iv := cipherTextBytes[:blockSize]
-	cipherTextBytes = cipherTextBytes[blockSize:]
-
-	stream := cipher.NewCFBDecrypter(block, iv)
-	stream.XORKeyStream(cipherTextBytes, cipherTextBytes)
-
-	return string(cipherTextBytes), nil
+	plaintext, err := open(r, c, keyID, cipherText, aad)
+	if err != nil && r.Authenticated() {
+		// An authenticated legacy cipher text may happen to start with the
+		// envelope magic and version; it can be told apart as it verifies.
+		if plaintext, legacyErr := c.Open(cipherText, aad); legacyErr == nil {
+			return plaintext, nil
+		}
+	}
+	return plaintext, err
 }
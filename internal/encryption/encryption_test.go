@@ -1,7 +1,8 @@
 package encryption
 
 import (
-	"encoding/base64"
+	"bytes"
+	"errors"
 	"fmt"
 	"testing"
 )
@@ -16,25 +17,30 @@ func TestEncryptDecrypt(t *testing.T) {
 		{AES192, "0123456789abcdefghijklmo", "Hasta la vista, baby"},
 		{AES256, "0123456789abcdefghijklmopqrstuvw", "Get to the chopper!"},
 		{DES, "01234567", "I need your clothes, your boots, and your motorcycle"},
+		{AES128GCM, "0123456789abcdef", "Come with me if you want to live"},
+		{AES256GCM, "0123456789abcdefghijklmopqrstuvw", "It's not a tumor!"},
+		{ChaCha20Poly1305, "0123456789abcdefghijklmopqrstuvw", "Consider that a divorce"},
+		{XChaCha20Poly1305, "0123456789abcdefghijklmopqrstuvw", "Stick around"},
+		{AES256GCM, "0123456789abcdefghijklmopqrstuvw", "\x00\xff\xfe binary \x80"},
 	}
 
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("Algorithm: %s", tc.algo), func(t *testing.T) {
-			cipherText, err := Encrypt(tc.algo, []byte(tc.key), tc.plaintext)
+			cipherText, err := Encrypt(tc.algo, []byte(tc.key), KeyID{}, []byte(tc.plaintext), nil)
 			if err != nil {
 				t.Errorf("encryption failed: %v", err)
 			}
 
-			if !isBase64(cipherText) {
-				t.Errorf("expected ciphertext to be base64 encoded, got %q", cipherText)
+			if bytes.Contains(cipherText, []byte(tc.plaintext)) {
+				t.Errorf("expected ciphertext not to contain the plaintext, got %q", cipherText)
 			}
 
 			// Decrypt the cipher text
-			decryptedText, err := Decrypt(tc.algo, []byte(tc.key), cipherText)
+			decryptedText, err := Decrypt(tc.algo, []byte(tc.key), KeyID{}, cipherText, nil)
 			if err != nil {
 				t.Errorf("decryption failed: %v", err)
 			}
-			if decryptedText != tc.plaintext {
+			if !bytes.Equal(decryptedText, []byte(tc.plaintext)) {
 				t.Errorf("decrypted text does not match plaintext, expected: %q, got: %q",
 					tc.plaintext,
 					decryptedText)
@@ -43,7 +49,78 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
-func isBase64(s string) bool {
-	_, err := base64.StdEncoding.DecodeString(s)
-	return err == nil
+func TestDecryptTamperedCipherText(t *testing.T) {
+	testCases := []struct {
+		algo Algorithm
+		key  string
+	}{
+		{AES128GCM, "0123456789abcdef"},
+		{AES256GCM, "0123456789abcdefghijklmopqrstuvw"},
+		{ChaCha20Poly1305, "0123456789abcdefghijklmopqrstuvw"},
+		{XChaCha20Poly1305, "0123456789abcdefghijklmopqrstuvw"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("Algorithm: %s", tc.algo), func(t *testing.T) {
+			cipherText, err := Encrypt(tc.algo, []byte(tc.key), KeyID{}, []byte("Who is your daddy, and what does he do?"), nil)
+			if err != nil {
+				t.Fatalf("encryption failed: %v", err)
+			}
+
+			cipherText[len(cipherText)-1] ^= 0x01
+
+			_, err = Decrypt(tc.algo, []byte(tc.key), KeyID{}, cipherText, nil)
+			if !errors.Is(err, ErrAuthenticationFailed) {
+				t.Errorf("expected ErrAuthenticationFailed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecryptShortCipherText(t *testing.T) {
+	_, err := Decrypt(AES128, []byte("0123456789abcdef"), KeyID{}, []byte("short"), nil)
+	if !errors.Is(err, ErrInvalidCipherTextBlockSize) {
+		t.Errorf("expected ErrInvalidCipherTextBlockSize, got %v", err)
+	}
+}
+
+func TestAdditionalAuthenticatedData(t *testing.T) {
+	key := []byte("0123456789abcdefghijklmopqrstuvw")
+	aad := []byte("tenant-1234")
+
+	cipherText, err := Encrypt(AES256GCM, key, KeyID{}, []byte("You're fired"), aad)
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+
+	t.Run("Matching AAD", func(t *testing.T) {
+		plaintext, err := Decrypt(AES256GCM, key, KeyID{}, cipherText, aad)
+		if err != nil {
+			t.Errorf("decryption failed: %v", err)
+		}
+		if string(plaintext) != "You're fired" {
+			t.Errorf("expected %q, got %q", "You're fired", plaintext)
+		}
+	})
+
+	t.Run("Mismatched AAD", func(t *testing.T) {
+		_, err := Decrypt(AES256GCM, key, KeyID{}, cipherText, []byte("tenant-5678"))
+		if !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("expected ErrAuthenticationFailed, got %v", err)
+		}
+	})
+
+	t.Run("Missing AAD", func(t *testing.T) {
+		_, err := Decrypt(AES256GCM, key, KeyID{}, cipherText, nil)
+		if !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("expected ErrAuthenticationFailed, got %v", err)
+		}
+	})
+
+	t.Run("Unauthenticated algorithm", func(t *testing.T) {
+		_, err := Encrypt(AES256, key, KeyID{}, []byte("You're fired"), aad)
+		if !errors.Is(err, ErrAADNotSupported) {
+			t.Errorf("expected ErrAADNotSupported, got %v", err)
+		}
+	})
 }
@@ -0,0 +1,171 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// Cipher texts produced by Encrypt are wrapped in a self-describing envelope:
+//
+//	magic (1) | version (1) | algorithm ID (1) | key ID (4) | nonce | cipher text | tag
+//
+// The nonce, cipher text and tag are the output of the algorithm's Cipher.Seal
+// (there is no tag for unauthenticated algorithms). For AEAD algorithms the
+// header is authenticated along with any caller supplied additional data, so
+// it cannot be altered without detection.
+//
+// Cipher texts written before the envelope was introduced have no header and
+// start directly with their nonce (or IV). Decrypt tells the two apart by the
+// magic and version bytes, so roughly one in 65536 legacy cipher texts from an
+// unauthenticated algorithm will be mistaken for an envelope and rejected;
+// legacy AEAD cipher texts are always recognised as they can be verified.
+const (
+	envelopeMagic   byte = 0xc7
+	envelopeVersion byte = 1
+
+	// HeaderSize is the length in bytes of the envelope header, which also
+	// starts every stream (see stream.go).
+	HeaderSize = 3 + KeyIDSize
+
+	// KeyIDSize is the length in bytes of a KeyID.
+	KeyIDSize = 4
+)
+
+var (
+	// ErrInvalidEnvelope indicates that a cipher text is not a well formed
+	// envelope.
+	ErrInvalidEnvelope = errors.New("invalid ciphertext envelope")
+
+	// ErrUnsupportedVersion indicates that a cipher text envelope was written
+	// in a format version this package does not understand.
+	ErrUnsupportedVersion = errors.New("unsupported ciphertext envelope version")
+
+	// ErrAlgorithmMismatch indicates that a cipher text was encrypted with a
+	// different algorithm to the one it is being decrypted with.
+	ErrAlgorithmMismatch = errors.New("ciphertext was encrypted with a different algorithm")
+
+	// ErrKeyMismatch indicates that a cipher text was encrypted with a
+	// different key to the one it is being decrypted with.
+	ErrKeyMismatch = errors.New("ciphertext was encrypted with a different key")
+
+	// ErrInvalidKeyID indicates a key ID which is not KeyIDSize bytes of hex.
+	ErrInvalidKeyID = errors.New("invalid key id")
+)
+
+// KeyID is a short identifier for a key, given to Encrypt and recorded in the
+// envelope so that the key a cipher text needs can be picked from several.
+// Key IDs are random rather than derived from the key, so they reveal nothing
+// about it, and must be stored alongside the key they identify.
+type KeyID [KeyIDSize]byte
+
+// NewKeyID returns a random key ID.
+func NewKeyID() (KeyID, error) {
+	var id KeyID
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return KeyID{}, errors.Join(ErrGeneratingKey, err)
+	}
+	return id, nil
+}
+
+// ParseKeyID parses a key ID in hex, as returned by KeyID.String, in either
+// case. ErrInvalidKeyID is returned if it is not a valid key ID.
+func ParseKeyID(s string) (KeyID, error) {
+	var id KeyID
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != KeyIDSize {
+		return KeyID{}, ErrInvalidKeyID
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// String returns the key ID in lower case hex.
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Header describes an enveloped cipher text.
+type Header struct {
+	Version   byte
+	Algorithm Algorithm
+	KeyID     KeyID
+}
+
+// ParseHeader reads the envelope header of a cipher text produced by Encrypt.
+// ErrInvalidEnvelope is returned if the cipher text does not start with an
+// envelope header, ErrUnsupportedVersion if the header is of an unknown format
+// version and ErrUnsupportedAlgorithm if the algorithm ID is not registered.
+func ParseHeader(cipherText []byte) (Header, error) {
+	if len(cipherText) < HeaderSize || cipherText[0] != envelopeMagic {
+		return Header{}, ErrInvalidEnvelope
+	}
+	if cipherText[1] != envelopeVersion {
+		return Header{}, ErrUnsupportedVersion
+	}
+
+	algo, ok := lookupID(cipherText[2])
+	if !ok {
+		return Header{}, ErrUnsupportedAlgorithm
+	}
+
+	h := Header{Version: cipherText[1], Algorithm: algo}
+	copy(h.KeyID[:], cipherText[3:HeaderSize])
+	return h, nil
+}
+
+// header returns the envelope header for a cipher text sealed by the given
+// algorithm under the key with the given ID.
+func header(r Registration, keyID KeyID) []byte {
+	return append([]byte{envelopeMagic, envelopeVersion, r.ID}, keyID[:]...)
+}
+
+// seal encrypts the plaintext and wraps the result in an envelope.
+func seal(r Registration, c Cipher, keyID KeyID, plaintext, aad []byte) ([]byte, error) {
+	h := header(r, keyID)
+	sealed, err := c.Seal(plaintext, additionalData(r, h, aad))
+	if err != nil {
+		return nil, err
+	}
+	return append(h, sealed...), nil
+}
+
+// open checks the envelope header of a cipher text against the algorithm and
+// key ID and decrypts it.
+func open(r Registration, c Cipher, keyID KeyID, cipherText, aad []byte) ([]byte, error) {
+	parsed, err := ParseHeader(cipherText)
+	if errors.Is(err, ErrUnsupportedAlgorithm) {
+		return nil, ErrAlgorithmMismatch // Certainly not the algorithm given.
+	}
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Algorithm != r.Name {
+		return nil, ErrAlgorithmMismatch
+	}
+	if parsed.KeyID != keyID {
+		return nil, ErrKeyMismatch
+	}
+
+	h := cipherText[:HeaderSize]
+	return c.Open(cipherText[HeaderSize:], additionalData(r, h, aad))
+}
+
+// isEnvelope returns true if the cipher text looks like an envelope of the
+// current format version rather than a legacy, header-less cipher text.
+func isEnvelope(cipherText []byte) bool {
+	return len(cipherText) >= 2 &&
+		cipherText[0] == envelopeMagic &&
+		cipherText[1] == envelopeVersion
+}
+
+// additionalData returns the additional data to authenticate for an
+// enveloped cipher text; the header is only authenticated by AEAD algorithms.
+func additionalData(r Registration, h, aad []byte) []byte {
+	if !r.Authenticated() {
+		return aad
+	}
+	return bytes.Join([][]byte{h, aad}, nil)
+}
@@ -0,0 +1,142 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewKeyID(t *testing.T) {
+	id, err := NewKeyID()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other, _ := NewKeyID(); other == id {
+		t.Errorf("expected random key IDs, got %s twice", id)
+	}
+
+	t.Run("Parse", func(t *testing.T) {
+		for _, s := range []string{id.String(), strings.ToUpper(id.String())} {
+			if parsed, err := ParseKeyID(s); err != nil || parsed != id {
+				t.Errorf("expected %q to parse as %s, got %s, %v", s, id, parsed, err)
+			}
+		}
+	})
+
+	t.Run("Parse invalid", func(t *testing.T) {
+		for _, s := range []string{"", "0123", "0123456789", "not hex!"} {
+			if _, err := ParseKeyID(s); !errors.Is(err, ErrInvalidKeyID) {
+				t.Errorf("expected ErrInvalidKeyID parsing %q, got %v", s, err)
+			}
+		}
+	})
+}
+
+func TestParseHeader(t *testing.T) {
+	key := []byte("0123456789abcdefghijklmopqrstuvw")
+	keyID := KeyID{1, 2, 3, 4}
+	for _, algo := range []Algorithm{AES256, AES256GCM, ChaCha20Poly1305} {
+		t.Run(fmt.Sprintf("Algorithm: %s", algo), func(t *testing.T) {
+			cipherText, err := Encrypt(algo, key, keyID, []byte("Do it now!"), nil)
+			if err != nil {
+				t.Fatalf("encryption failed: %v", err)
+			}
+
+			h, err := ParseHeader(cipherText)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if h.Version != envelopeVersion || h.Algorithm != algo || h.KeyID != keyID {
+				t.Errorf("expected version %d, algorithm %s and key ID %s, got %+v",
+					envelopeVersion,
+					algo,
+					keyID,
+					h)
+			}
+		})
+	}
+
+	t.Run("Not an envelope", func(t *testing.T) {
+		if _, err := ParseHeader([]byte("short")); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Errorf("expected ErrInvalidEnvelope, got %v", err)
+		}
+	})
+}
+
+func TestDecryptEnvelopeMismatch(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	keyID, otherKeyID := KeyID{1, 2, 3, 4}, KeyID{4, 3, 2, 1}
+
+	testCases := []struct {
+		name     string
+		encAlg   Algorithm
+		decAlg   Algorithm
+		decKeyID KeyID
+		tamper   func(cipherText []byte)
+		err      error
+	}{
+		{"Algorithm", AES128GCM, AES128, keyID, nil, ErrAlgorithmMismatch},
+		{"Algorithm (unauthenticated)", AES128, AES128GCM, keyID, nil, ErrAlgorithmMismatch},
+		{"Key", AES128GCM, AES128GCM, otherKeyID, nil, ErrKeyMismatch},
+		{"Key (unauthenticated)", AES128, AES128, otherKeyID, nil, ErrKeyMismatch},
+		{"Version", AES128GCM, AES128GCM, keyID, func(ct []byte) { ct[1] = 2 }, ErrUnsupportedVersion},
+		{"Tampered key ID", AES128GCM, AES128GCM, keyID, func(ct []byte) { ct[3] ^= 0x01 }, ErrKeyMismatch},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cipherText, err := Encrypt(tc.encAlg, key, keyID, []byte("Let off some steam, Bennett"), nil)
+			if err != nil {
+				t.Fatalf("encryption failed: %v", err)
+			}
+			if tc.tamper != nil {
+				tc.tamper(cipherText)
+			}
+
+			_, err = Decrypt(tc.decAlg, key, tc.decKeyID, cipherText, nil)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestDecryptLegacyCipherText(t *testing.T) {
+	testCases := []struct {
+		algo Algorithm
+		key  string
+	}{
+		{AES128, "0123456789abcdef"},
+		{DES, "01234567"},
+		{AES256GCM, "0123456789abcdefghijklmopqrstuvw"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("Algorithm: %s", tc.algo), func(t *testing.T) {
+			plaintext := []byte("You're a funny guy, Sully")
+			_, c, err := newCipher(tc.algo, []byte(tc.key))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Legacy cipher texts are the bare output of Seal. Avoid the
+			// rare nonce which collides with the envelope header.
+			var legacy []byte
+			for legacy == nil || isEnvelope(legacy) {
+				if legacy, err = c.Seal(plaintext, nil); err != nil {
+					t.Fatalf("encryption failed: %v", err)
+				}
+			}
+
+			decrypted, err := Decrypt(tc.algo, []byte(tc.key), KeyID{}, legacy, nil)
+			if err != nil {
+				t.Fatalf("decryption failed: %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("expected %q, got %q", plaintext, decrypted)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// AES in Galois/Counter Mode.
+const (
+	AES128GCM Algorithm = "aes128-gcm"
+	AES256GCM Algorithm = "aes256-gcm"
+)
+
+func init() {
+	newGCM := func(key []byte) (cipher.AEAD, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+
+	Register(Registration{Name: AES128GCM, ID: 5, KeySizes: []int{16}, NonceSize: 12, NewAEAD: newGCM})
+	Register(Registration{Name: AES256GCM, ID: 6, KeySizes: []int{32}, NonceSize: 12, NewAEAD: newGCM})
+}
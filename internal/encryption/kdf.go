@@ -0,0 +1,191 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF is the name of a password based key derivation function.
+type KDF string
+
+// Supported key derivation functions. PBKDF2 uses HMAC-SHA-256.
+const (
+	Argon2id KDF = "argon2id"
+	Scrypt   KDF = "scrypt"
+	PBKDF2   KDF = "pbkdf2"
+)
+
+// Bounds on key derivation parameters. The upper bounds stop a single
+// derivation from monopolising the CPU or memory of the service; derivations
+// run per request, so they are kept to a few times the defaults.
+const (
+	kdfSaltSize    = 16
+	kdfMinSaltSize = 8
+
+	argon2MaxTime    = 16
+	argon2MaxMemory  = 64 * 1024 // KiB
+	argon2MaxThreads = 4
+
+	scryptMaxN      = 1 << 20
+	scryptMaxR      = 32
+	scryptMaxP      = 4
+	scryptMaxMemory = 128 * 1024 * 1024 // Bytes, 128 * N * r; as much as the defaults need.
+
+	pbkdf2MinIterations = 1000
+	pbkdf2MaxIterations = 10_000_000
+)
+
+var (
+	// ErrUnsupportedKDF indicates that the requested key derivation function
+	// is not supported.
+	ErrUnsupportedKDF = errors.New("unsupported key derivation function")
+
+	// ErrInvalidKDFParams indicates key derivation parameters which are out
+	// of bounds for the key derivation function.
+	ErrInvalidKDFParams = errors.New("invalid key derivation parameters")
+)
+
+// KDFParams holds the parameters of a key derivation. Only the parameters of
+// the chosen KDF are used; zero values select the defaults, which follow the
+// OWASP password storage recommendations.
+type KDFParams struct {
+	KDF  KDF
+	Salt []byte // Generated randomly if empty.
+
+	// Argon2id.
+	Time    uint32 // Number of passes over the memory.
+	Memory  uint32 // Memory in KiB.
+	Threads uint8
+
+	// scrypt.
+	N int // CPU and memory cost, a power of two.
+	R int // Block size.
+	P int // Parallelisation.
+
+	// PBKDF2.
+	Iterations int
+}
+
+// KDFs returns the sorted list of supported key derivation functions.
+func KDFs() []string {
+	return []string{string(Argon2id), string(PBKDF2), string(Scrypt)}
+}
+
+// DeriveKey derives a key of the correct size for the given algorithm from a
+// passphrase. Where an algorithm accepts several key sizes the largest is
+// used. The parameters actually used, with defaults filled in and any
+// generated salt, are returned alongside the key; deriving again with them
+// and the same passphrase gives the same key.
+func DeriveKey(algo Algorithm, passphrase []byte, params KDFParams) ([]byte, KDFParams, error) {
+	r, ok := Lookup(algo)
+	if !ok {
+		return nil, params, ErrUnsupportedAlgorithm
+	}
+
+	params, err := params.withDefaults()
+	if err != nil {
+		return nil, params, err
+	}
+	if err := params.validate(); err != nil {
+		return nil, params, err
+	}
+
+	size := slices.Max(r.KeySizes)
+	switch params.KDF {
+	case Argon2id:
+		return argon2.IDKey(passphrase, params.Salt, params.Time, params.Memory, params.Threads, uint32(size)), params, nil
+	case Scrypt:
+		key, err := scrypt.Key(passphrase, params.Salt, params.N, params.R, params.P, size)
+		if err != nil {
+			return nil, params, errors.Join(ErrInvalidKDFParams, err)
+		}
+		return key, params, nil
+	default:
+		return pbkdf2.Key(passphrase, params.Salt, params.Iterations, size, sha256.New), params, nil
+	}
+}
+
+// withDefaults returns a copy of the parameters with the defaults for the KDF
+// filled in and a random salt generated if none is given.
+func (p KDFParams) withDefaults() (KDFParams, error) {
+	switch p.KDF {
+	case Argon2id:
+		p.Time = or(p.Time, 2)
+		p.Memory = or(p.Memory, 19*1024)
+		p.Threads = or(p.Threads, 1)
+	case Scrypt:
+		p.N = or(p.N, 1<<17)
+		p.R = or(p.R, 8)
+		p.P = or(p.P, 1)
+	case PBKDF2:
+		p.Iterations = or(p.Iterations, 600_000)
+	default:
+		return p, ErrUnsupportedKDF
+	}
+
+	if len(p.Salt) == 0 {
+		p.Salt = make([]byte, kdfSaltSize)
+		if _, err := io.ReadFull(rand.Reader, p.Salt); err != nil {
+			return p, errors.Join(ErrGeneratingKey, err)
+		}
+	}
+
+	return p, nil
+}
+
+// validate checks that the parameters of the KDF are within bounds.
+func (p KDFParams) validate() error {
+	invalid := func(format string, a ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidKDFParams, fmt.Sprintf(format, a...))
+	}
+
+	if len(p.Salt) < kdfMinSaltSize {
+		return invalid("salt must be at least %d bytes", kdfMinSaltSize)
+	}
+
+	switch p.KDF {
+	case Argon2id:
+		if p.Time > argon2MaxTime {
+			return invalid("argon2id time must be at most %d", argon2MaxTime)
+		}
+		if p.Threads > argon2MaxThreads {
+			return invalid("argon2id threads must be at most %d", argon2MaxThreads)
+		}
+		if p.Memory < 8*uint32(p.Threads) || p.Memory > argon2MaxMemory {
+			return invalid("argon2id memory must be between %d and %d KiB", 8*uint32(p.Threads), argon2MaxMemory)
+		}
+	case Scrypt:
+		if p.N <= 1 || p.N&(p.N-1) != 0 || p.N > scryptMaxN {
+			return invalid("scrypt N must be a power of two between 2 and %d", scryptMaxN)
+		}
+		if p.R < 1 || p.R > scryptMaxR || p.P < 1 || p.P > scryptMaxP {
+			return invalid("scrypt r must be between 1 and %d and p between 1 and %d", scryptMaxR, scryptMaxP)
+		}
+		if 128*p.N*p.R > scryptMaxMemory {
+			return invalid("scrypt N and r require more than %d MiB", scryptMaxMemory>>20)
+		}
+	case PBKDF2:
+		if p.Iterations < pbkdf2MinIterations || p.Iterations > pbkdf2MaxIterations {
+			return invalid("pbkdf2 iterations must be between %d and %d", pbkdf2MinIterations, pbkdf2MaxIterations)
+		}
+	}
+
+	return nil
+}
+
+// or returns v, or def if v is zero.
+func or[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+	return v
+}
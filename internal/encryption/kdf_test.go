@@ -0,0 +1,98 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	testCases := []struct {
+		name   string
+		algo   Algorithm
+		params KDFParams
+	}{
+		{"Argon2id", AES256GCM, KDFParams{KDF: Argon2id, Time: 1, Memory: 64}},
+		{"scrypt", AES192, KDFParams{KDF: Scrypt, N: 1 << 10}},
+		{"PBKDF2", DES, KDFParams{KDF: PBKDF2, Iterations: 1000}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, params, err := DeriveKey(tc.algo, []byte("correct horse battery staple"), tc.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !ValidateAlgoKeyPair(tc.algo, key) {
+				t.Errorf("derived %d byte key is not valid for %s", len(key), tc.algo)
+			}
+			if len(params.Salt) != kdfSaltSize {
+				t.Errorf("expected a %d byte salt to be generated, got %d bytes", kdfSaltSize, len(params.Salt))
+			}
+
+			again, _, err := DeriveKey(tc.algo, []byte("correct horse battery staple"), params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(key, again) {
+				t.Error("expected the returned parameters to derive the same key")
+			}
+
+			other, _, _ := DeriveKey(tc.algo, []byte("Tr0ub4dor&3"), params)
+			if bytes.Equal(key, other) {
+				t.Error("expected a different passphrase to derive a different key")
+			}
+		})
+	}
+}
+
+func TestDeriveKeyDefaults(t *testing.T) {
+	_, params, err := DeriveKey(AES128, []byte("passphrase"), KDFParams{KDF: PBKDF2, Salt: []byte("NaCl and more")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params.Iterations != 600_000 || string(params.Salt) != "NaCl and more" {
+		t.Errorf("expected default iterations and the given salt, got %+v", params)
+	}
+}
+
+func TestDeriveKeyKnownAnswer(t *testing.T) {
+	// The fifth RFC 6070 test case, computed with HMAC-SHA-256.
+	key, _, err := DeriveKey(AES256, []byte("passwordPASSWORDpassword"),
+		KDFParams{KDF: PBKDF2, Salt: []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), Iterations: 4096})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1"
+	if hex.EncodeToString(key) != expected {
+		t.Errorf("expected %s, got %x", expected, key)
+	}
+}
+
+func TestDeriveKeyInvalidParams(t *testing.T) {
+	testCases := []struct {
+		name   string
+		params KDFParams
+		err    error
+	}{
+		{"Unknown KDF", KDFParams{KDF: "md5"}, ErrUnsupportedKDF},
+		{"Short salt", KDFParams{KDF: PBKDF2, Salt: []byte("salt")}, ErrInvalidKDFParams},
+		{"Argon2id memory", KDFParams{KDF: Argon2id, Memory: 1 << 30}, ErrInvalidKDFParams},
+		{"Argon2id memory over 64 MiB", KDFParams{KDF: Argon2id, Memory: 64*1024 + 1}, ErrInvalidKDFParams},
+		{"Argon2id threads", KDFParams{KDF: Argon2id, Threads: 8}, ErrInvalidKDFParams},
+		{"scrypt N not a power of two", KDFParams{KDF: Scrypt, N: 1000}, ErrInvalidKDFParams},
+		{"scrypt memory", KDFParams{KDF: Scrypt, N: 1 << 20, R: 32}, ErrInvalidKDFParams},
+		{"scrypt memory over 128 MiB", KDFParams{KDF: Scrypt, N: 1 << 18}, ErrInvalidKDFParams},
+		{"scrypt parallelisation", KDFParams{KDF: Scrypt, N: 1 << 10, P: 8}, ErrInvalidKDFParams},
+		{"PBKDF2 iterations", KDFParams{KDF: PBKDF2, Iterations: 10}, ErrInvalidKDFParams},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := DeriveKey(AES128, []byte("passphrase"), tc.params); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Cipher is implemented by every algorithm offered by this package. A Cipher
+// is bound to a single key at construction time.
+type Cipher interface {
+	// Seal encrypts the plaintext and returns the nonce (or IV) followed by
+	// the cipher text. The additional data is authenticated but not
+	// encrypted; implementations which cannot authenticate must return
+	// ErrAADNotSupported when it is non-empty.
+	Seal(plaintext, additionalData []byte) ([]byte, error)
+
+	// Open reverses Seal, taking a nonce prefixed cipher text and returning
+	// the plaintext. The additional data must match that given to Seal.
+	Open(cipherText, additionalData []byte) ([]byte, error)
+}
+
+// Registration describes an algorithm to the registry.
+type Registration struct {
+	// Name is the name the algorithm is advertised and selected under.
+	Name Algorithm
+
+	// ID identifies the algorithm in the header of enveloped cipher texts
+	// (see envelope.go). It must be non-zero, unique and never reused once
+	// cipher texts have been written with it.
+	ID byte
+
+	// KeySizes lists every valid key length in bytes.
+	KeySizes []int
+
+	// NonceSize is the length in bytes of the nonce (or IV) prepended to
+	// every cipher text.
+	NonceSize int
+
+	// New returns a Cipher bound to the given key. The key length has
+	// already been validated against KeySizes when New is called. New may be
+	// omitted for AEAD algorithms which set NewAEAD instead.
+	New func(key []byte) (Cipher, error)
+
+	// NewAEAD returns the underlying cipher.AEAD for authenticated
+	// algorithms and is nil otherwise. Features which depend on
+	// authentication, such as streaming, are only offered when it is set.
+	NewAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+// Authenticated returns true if the algorithm is an AEAD algorithm.
+func (r Registration) Authenticated() bool {
+	return r.NewAEAD != nil
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[Algorithm]Registration)
+	registryID = make(map[byte]Algorithm)
+)
+
+// Register makes an algorithm available to the rest of the package. It is
+// intended to be called from the init function of the file implementing the
+// algorithm and panics if the registration is incomplete or the name or ID is
+// already taken.
+func Register(r Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if r.New == nil && r.NewAEAD != nil {
+		newAEAD := r.NewAEAD
+		r.New = func(key []byte) (Cipher, error) {
+			aead, err := newAEAD(key)
+			if err != nil {
+				return nil, err
+			}
+			return &aeadCipher{aead: aead}, nil
+		}
+	}
+	if r.Name == "" || r.ID == 0 || r.New == nil || len(r.KeySizes) == 0 {
+		panic(fmt.Sprintf("encryption: incomplete registration for %q", r.Name))
+	}
+	if _, dup := registry[r.Name]; dup {
+		panic(fmt.Sprintf("encryption: Register called twice for %q", r.Name))
+	}
+	if other, dup := registryID[r.ID]; dup {
+		panic(fmt.Sprintf("encryption: ID %d of %q already registered for %q", r.ID, r.Name, other))
+	}
+	registry[r.Name] = r
+	registryID[r.ID] = r.Name
+}
+
+// Lookup returns the registration for the given algorithm and a boolean
+// indicating whether the algorithm is registered.
+func Lookup(algo Algorithm) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	r, ok := registry[algo]
+	return r, ok
+}
+
+// Algorithms returns the sorted list of supported symmetric algorithms.
+func Algorithms() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, string(name))
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// lookupID returns the name of the algorithm registered under the given ID
+// and a boolean indicating whether one is registered.
+func lookupID(id byte) (Algorithm, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	algo, ok := registryID[id]
+	return algo, ok
+}
+
+// newCipher looks up the algorithm and constructs a Cipher for the key. The
+// registration is returned alongside it.
+func newCipher(algo Algorithm, key []byte) (Registration, Cipher, error) {
+	r, ok := Lookup(algo)
+	if !ok {
+		return r, nil, ErrUnsupportedAlgorithm
+	}
+	if !slices.Contains(r.KeySizes, len(key)) {
+		return r, nil, errors.Join(ErrCipherCreation, fmt.Errorf("invalid key size %d", len(key)))
+	}
+
+	c, err := r.New(key)
+	if err != nil {
+		return r, nil, errors.Join(ErrCipherCreation, err)
+	}
+
+	return r, c, nil
+}
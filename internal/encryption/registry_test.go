@@ -0,0 +1,99 @@
+package encryption
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAlgorithms(t *testing.T) {
+	want := []string{
+		"aes128",
+		"aes128-gcm",
+		"aes192",
+		"aes256",
+		"aes256-gcm",
+		"chacha20-poly1305",
+		"des",
+		"xchacha20-poly1305",
+	}
+
+	got := Algorithms()
+	if !slices.Equal(got, want) {
+		t.Errorf("expected algorithms %v, got %v", want, got)
+	}
+}
+
+func TestValidateAlgoKeyPair(t *testing.T) {
+	testCases := []struct {
+		algo  Algorithm
+		key   string
+		valid bool
+	}{
+		{AES128, "0123456789abcdef", true},
+		{AES128, "0123456789abcde", false},
+		{AES192, "0123456789abcdefghijklmo", true},
+		{AES256GCM, "0123456789abcdefghijklmopqrstuvw", true},
+		{ChaCha20Poly1305, "0123456789abcdef", false},
+		{DES, "01234567", true},
+		{Algorithm("rot13"), "01234567", false},
+	}
+
+	for _, tc := range testCases {
+		if got := ValidateAlgoKeyPair(tc.algo, []byte(tc.key)); got != tc.valid {
+			t.Errorf("ValidateAlgoKeyPair(%q, %d byte key): expected %t, got %t",
+				tc.algo,
+				len(tc.key),
+				tc.valid,
+				got)
+		}
+	}
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected duplicate registration to panic")
+		}
+	}()
+
+	r, _ := Lookup(AES128)
+	Register(r)
+}
+
+func TestEncryptUnsupportedAlgorithm(t *testing.T) {
+	_, err := Encrypt(Algorithm("rot13"), []byte("01234567"), KeyID{}, []byte("plaintext"), nil)
+	if err != ErrUnsupportedAlgorithm {
+		t.Errorf("expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	for _, name := range Algorithms() {
+		algo := Algorithm(name)
+		t.Run(name, func(t *testing.T) {
+			key, err := GenerateKey(algo)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !ValidateAlgoKeyPair(algo, key) {
+				t.Errorf("generated %d byte key is not valid for %s", len(key), algo)
+			}
+		})
+	}
+
+	if _, err := GenerateKey(Algorithm("rot13")); err != ErrUnsupportedAlgorithm {
+		t.Errorf("expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+func TestRegisterDuplicateIDPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected duplicate ID registration to panic")
+		}
+	}()
+
+	r, _ := Lookup(AES128)
+	r.Name = "aes128-again"
+	Register(r)
+}
@@ -0,0 +1,337 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Streaming encryption splits a plaintext of arbitrary length into fixed size
+// segments which are sealed individually following the STREAM construction
+// (Hoang, Reyhanitabar, Rogaway and Vizár, 2015), much like the age file
+// format. The framed cipher text looks like:
+//
+//	header || salt || segment 0 || segment 1 || ... || final segment
+//
+// The header is the envelope header (see envelope.go), recording the algorithm
+// and key ID, and is authenticated along with every segment. Streams written
+// before the header was introduced start directly with their salt; they are
+// told apart by the magic and version bytes, so roughly one in 65536 of them
+// will be mistaken for a stream with a header and rejected.
+//
+// A per-stream key is derived from the session key and the random salt using
+// HKDF-SHA256, so segment nonces never repeat across streams. Every segment
+// carries StreamSegmentSize bytes of plaintext, except the final one which
+// may carry fewer, plus the AEAD tag. Each segment is sealed under a nonce
+// made up of a big endian segment counter followed by a flag byte which is 1
+// for the final segment and 0 otherwise; reordering, dropping, truncating or
+// appending segments therefore causes authentication to fail.
+
+const (
+	// StreamSegmentSize is the number of plaintext bytes in each segment.
+	StreamSegmentSize = 64 * 1024
+
+	streamSaltSize = 16
+	streamInfo     = "atostechtest stream v1"
+)
+
+var (
+	// ErrStreamingNotSupported indicates that streaming was requested for
+	// an algorithm which is not an AEAD algorithm.
+	ErrStreamingNotSupported = errors.New("streaming requires an AEAD algorithm")
+
+	// ErrStreamTruncated indicates that a cipher text stream ended before
+	// its final segment.
+	ErrStreamTruncated = errors.New("cipher text stream truncated")
+
+	// ErrStreamClosed is returned when writing to a closed stream.
+	ErrStreamClosed = errors.New("write to closed stream")
+)
+
+// NewStreamEncrypter returns a WriteCloser which encrypts everything written
+// to it under the key with the given ID and writes the framed cipher text to
+// w. Memory use is bounded by the segment size regardless of the amount of
+// data written. Close must be called to write the final segment; it does not
+// close w.
+func NewStreamEncrypter(algo Algorithm, key []byte, keyID KeyID, w io.Writer) (io.WriteCloser, error) {
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, errors.Join(ErrGeneratingIV, err)
+	}
+
+	aead, err := newStreamAEAD(algo, key, salt)
+	if err != nil {
+		return nil, err
+	}
+	r, _ := Lookup(algo) // Known to exist by newStreamAEAD.
+	h := header(r, keyID)
+
+	return &streamEncrypter{
+		aead:   aead,
+		w:      w,
+		header: append(bytes.Clone(h), salt...),
+		ad:     h,
+		nonce:  make([]byte, aead.NonceSize()),
+		buf:    make([]byte, 0, StreamSegmentSize),
+	}, nil
+}
+
+// NewStreamDecrypter returns a Reader which decrypts the framed cipher text
+// read from r, as produced by NewStreamEncrypter under the key with the given
+// ID. Plaintext is only released once the segment containing it has been
+// authenticated. A stream which has been tampered with results in
+// ErrAuthenticationFailed from Read, and one encrypted with another algorithm
+// or key ID in ErrAlgorithmMismatch or ErrKeyMismatch respectively. Legacy
+// streams, written before the header was introduced, are still accepted.
+func NewStreamDecrypter(algo Algorithm, key []byte, keyID KeyID, r io.Reader) (io.Reader, error) {
+	// Check the algorithm and key up front so that callers learn of these
+	// errors before they begin reading.
+	if _, err := newStreamAEAD(algo, key, make([]byte, streamSaltSize)); err != nil {
+		return nil, err
+	}
+
+	return &streamDecrypter{
+		algo:  algo,
+		key:   key,
+		keyID: keyID,
+		r:     bufio.NewReader(r),
+	}, nil
+}
+
+// newStreamAEAD derives the per-stream key from key and salt and returns the
+// AEAD for it.
+func newStreamAEAD(algo Algorithm, key, salt []byte) (cipher.AEAD, error) {
+	r, ok := Lookup(algo)
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	if !r.Authenticated() {
+		return nil, ErrStreamingNotSupported
+	}
+	if !ValidateAlgoKeyPair(algo, key) {
+		return nil, ErrCipherCreation
+	}
+
+	streamKey := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(streamInfo)), streamKey); err != nil {
+		return nil, errors.Join(ErrCipherCreation, err)
+	}
+
+	aead, err := r.NewAEAD(streamKey)
+	if err != nil {
+		return nil, errors.Join(ErrCipherCreation, err)
+	}
+
+	return aead, nil
+}
+
+// setStreamNonce writes the segment counter and final flag into nonce.
+func setStreamNonce(nonce []byte, counter uint64, last bool) {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+type streamEncrypter struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	header  []byte // Written ahead of the first segment then set to nil.
+	ad      []byte // Additional data authenticated with every segment.
+	nonce   []byte
+	counter uint64
+	buf     []byte // Plaintext awaiting encryption.
+	out     []byte
+	closed  bool
+	err     error
+}
+
+func (s *streamEncrypter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+
+	var n int
+	for len(p) > 0 {
+		if s.err != nil {
+			return n, s.err
+		}
+
+		// A full buffer is only sealed once more data arrives, as until
+		// then it may turn out to be the final segment.
+		if len(s.buf) == StreamSegmentSize {
+			s.err = s.flush(false)
+			continue
+		}
+
+		c := min(len(p), StreamSegmentSize-len(s.buf))
+		s.buf = append(s.buf, p[:c]...)
+		p = p[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close seals and writes the final segment.
+func (s *streamEncrypter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+
+	s.err = s.flush(true)
+	return s.err
+}
+
+func (s *streamEncrypter) flush(last bool) error {
+	if s.header != nil {
+		if _, err := s.w.Write(s.header); err != nil {
+			return err
+		}
+		s.header = nil
+	}
+
+	setStreamNonce(s.nonce, s.counter, last)
+	s.out = s.aead.Seal(s.out[:0], s.nonce, s.buf, s.ad)
+	if _, err := s.w.Write(s.out); err != nil {
+		return err
+	}
+
+	s.counter++
+	s.buf = s.buf[:0]
+
+	return nil
+}
+
+type streamDecrypter struct {
+	algo    Algorithm
+	key     []byte
+	keyID   KeyID
+	r       *bufio.Reader
+	aead    cipher.AEAD // Nil until the salt has been read.
+	ad      []byte      // The header, or nil for a legacy stream.
+	nonce   []byte
+	counter uint64
+	in      []byte // Buffer for one sealed segment.
+	out     []byte // Buffer for one opened segment.
+	plain   []byte // Opened plaintext not yet returned to the caller.
+	last    bool
+	err     error
+}
+
+func (s *streamDecrypter) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.last {
+			s.err = io.EOF
+			continue
+		}
+		s.err = s.next()
+	}
+
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+
+	return n, nil
+}
+
+// next reads, authenticates and decrypts the next segment.
+func (s *streamDecrypter) next() error {
+	if s.aead == nil {
+		if err := s.readHeader(); err != nil {
+			return err
+		}
+
+		salt := make([]byte, streamSaltSize)
+		if _, err := io.ReadFull(s.r, salt); err != nil {
+			return truncated(err)
+		}
+
+		aead, err := newStreamAEAD(s.algo, s.key, salt)
+		if err != nil {
+			return err
+		}
+		s.aead = aead
+		s.nonce = make([]byte, aead.NonceSize())
+		s.in = make([]byte, StreamSegmentSize+aead.Overhead())
+		s.out = make([]byte, 0, StreamSegmentSize)
+	}
+
+	n, err := io.ReadFull(s.r, s.in)
+	switch err {
+	case nil:
+		// A full segment is the final one only if nothing follows it.
+		if _, err := s.r.Peek(1); err == io.EOF {
+			s.last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		s.last = true
+	case io.EOF:
+		return ErrStreamTruncated
+	default:
+		return err
+	}
+
+	setStreamNonce(s.nonce, s.counter, s.last)
+	s.plain, err = s.aead.Open(s.out[:0], s.nonce, s.in[:n], s.ad)
+	if err != nil {
+		return ErrAuthenticationFailed
+	}
+	s.counter++
+
+	return nil
+}
+
+// readHeader reads the header of the stream, if it has one, and checks it
+// against the algorithm and key ID.
+func (s *streamDecrypter) readHeader() error {
+	if b, err := s.r.Peek(2); err != nil || b[0] != envelopeMagic || b[1] != envelopeVersion {
+		return nil // A legacy stream, or too short to be anything.
+	}
+
+	h := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(s.r, h); err != nil {
+		return truncated(err)
+	}
+	parsed, err := ParseHeader(h)
+	if errors.Is(err, ErrUnsupportedAlgorithm) {
+		return ErrAlgorithmMismatch // Certainly not the algorithm given.
+	}
+	if err != nil {
+		return err
+	}
+	if parsed.Algorithm != s.algo {
+		return ErrAlgorithmMismatch
+	}
+	if parsed.KeyID != s.keyID {
+		return ErrKeyMismatch
+	}
+
+	s.ad = h
+	return nil
+}
+
+// truncated returns ErrStreamTruncated for the errors io.ReadFull returns on
+// reaching the end of a stream early, and err otherwise.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrStreamTruncated
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// streamKeyID is the key ID streams are encrypted under in tests.
+var streamKeyID = KeyID{1, 2, 3, 4}
+
+func encryptStream(t *testing.T, algo Algorithm, key, plaintext []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	enc, err := NewStreamEncrypter(algo, key, streamKeyID, &buf)
+	if err != nil {
+		t.Fatalf("creating encrypter: %v", err)
+	}
+	// Write in awkwardly sized pieces to exercise segment boundaries.
+	for p := plaintext; len(p) > 0; {
+		n := min(len(p), 1000)
+		if _, err := enc.Write(p[:n]); err != nil {
+			t.Fatalf("writing: %v", err)
+		}
+		p = p[n:]
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("closing: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func decryptStream(algo Algorithm, key, cipherText []byte) ([]byte, error) {
+	dec, err := NewStreamDecrypter(algo, key, streamKeyID, bytes.NewReader(cipherText))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dec)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdefghijklmopqrstuvw")
+	sizes := []int{0, 1, StreamSegmentSize - 1, StreamSegmentSize, StreamSegmentSize + 1, 3 * StreamSegmentSize}
+
+	for _, algo := range []Algorithm{AES256GCM, ChaCha20Poly1305, XChaCha20Poly1305} {
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("%s/%d bytes", algo, size), func(t *testing.T) {
+				plaintext := make([]byte, size)
+				rand.Read(plaintext)
+
+				cipherText := encryptStream(t, algo, key, plaintext)
+
+				got, err := decryptStream(algo, key, cipherText)
+				if err != nil {
+					t.Fatalf("decryption failed: %v", err)
+				}
+				if !bytes.Equal(got, plaintext) {
+					t.Errorf("decrypted stream does not match plaintext")
+				}
+			})
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key := []byte("0123456789abcdefghijklmopqrstuvw")
+	plaintext := make([]byte, 2*StreamSegmentSize+100)
+	rand.Read(plaintext)
+	cipherText := encryptStream(t, AES256GCM, key, plaintext)
+	segment := StreamSegmentSize + 16 // Plaintext plus GCM tag.
+	prefix := HeaderSize + streamSaltSize
+
+	testCases := []struct {
+		name   string
+		mangle func([]byte) []byte
+		want   error
+	}{
+		{"Flipped bit", func(b []byte) []byte {
+			b[prefix+10] ^= 0x01
+			return b
+		}, ErrAuthenticationFailed},
+		{"Truncated at segment boundary", func(b []byte) []byte {
+			return b[:prefix+2*segment]
+		}, ErrAuthenticationFailed},
+		{"Final segment dropped mid way", func(b []byte) []byte {
+			return b[:len(b)-10]
+		}, ErrAuthenticationFailed},
+		{"Segments reordered", func(b []byte) []byte {
+			first := bytes.Clone(b[prefix : prefix+segment])
+			copy(b[prefix:], b[prefix+segment:prefix+2*segment])
+			copy(b[prefix+segment:], first)
+			return b
+		}, ErrAuthenticationFailed},
+		{"Data appended", func(b []byte) []byte {
+			return append(b, 0x00)
+		}, ErrAuthenticationFailed},
+		{"Key ID changed", func(b []byte) []byte {
+			b[3] ^= 0x01
+			return b
+		}, ErrKeyMismatch},
+		{"Algorithm changed", func(b []byte) []byte {
+			r, _ := Lookup(ChaCha20Poly1305)
+			b[2] = r.ID
+			return b
+		}, ErrAlgorithmMismatch},
+		{"Salt only", func(b []byte) []byte {
+			return b[:prefix]
+		}, ErrStreamTruncated},
+		{"Header only", func(b []byte) []byte {
+			return b[:HeaderSize]
+		}, ErrStreamTruncated},
+		{"Empty", func(b []byte) []byte {
+			return nil
+		}, ErrStreamTruncated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decryptStream(AES256GCM, key, tc.mangle(bytes.Clone(cipherText)))
+			if !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestStreamHeader(t *testing.T) {
+	key := []byte("0123456789abcdefghijklmopqrstuvw")
+	cipherText := encryptStream(t, AES256GCM, key, []byte("Consider that a divorce"))
+
+	h, err := ParseHeader(cipherText)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Algorithm != AES256GCM || h.KeyID != streamKeyID {
+		t.Errorf("expected algorithm %s and key ID %s, got %+v", AES256GCM, streamKeyID, h)
+	}
+
+	t.Run("Authenticated", func(t *testing.T) {
+		// Decrypting under the altered key ID gets past the header check,
+		// but the segments were sealed with the original header.
+		altered := bytes.Clone(cipherText)
+		altered[3] ^= 0x01
+		alteredID := streamKeyID
+		alteredID[0] ^= 0x01
+
+		dec, _ := NewStreamDecrypter(AES256GCM, key, alteredID, bytes.NewReader(altered))
+		if _, err := io.ReadAll(dec); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("expected ErrAuthenticationFailed, got %v", err)
+		}
+	})
+}
+
+func TestStreamLegacy(t *testing.T) {
+	key := []byte("0123456789abcdefghijklmopqrstuvw")
+	plaintext := []byte("I'll be back")
+
+	// Legacy streams are the salt followed by segments sealed without
+	// additional data. Avoid the rare salt which looks like a header.
+	salt := make([]byte, streamSaltSize)
+	rand.Read(salt)
+	salt[0] = ^envelopeMagic
+	aead, err := newStreamAEAD(AES256GCM, key, salt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	setStreamNonce(nonce, 0, true)
+	legacy := aead.Seal(salt, nonce, plaintext, nil)
+
+	got, err := decryptStream(AES256GCM, key, legacy)
+	if err != nil {
+		t.Fatalf("decryption failed: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("expected %q, got %q", plaintext, got)
+	}
+}
+
+func TestStreamRequiresAEAD(t *testing.T) {
+	_, err := NewStreamEncrypter(AES256, []byte("0123456789abcdefghijklmopqrstuvw"), KeyID{}, io.Discard)
+	if !errors.Is(err, ErrStreamingNotSupported) {
+		t.Errorf("expected ErrStreamingNotSupported, got %v", err)
+	}
+}
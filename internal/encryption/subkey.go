@@ -0,0 +1,46 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// subKeyInfo prefixes the context label in the HKDF info of every sub-key, so
+// that sub-keys cannot collide with keys derived elsewhere (see stream.go).
+const subKeyInfo = "atostechtest subkey v1:"
+
+// subKeyIDLabel prefixes the hash input of every sub-key ID.
+const subKeyIDLabel = "atostechtest subkey id v1:"
+
+// DeriveSubKey derives a sub-key for the named context from a key using HKDF
+// with SHA-256, so that one key can serve several purposes without being used
+// directly for any of them. The sub-key is the same length as the key, and so
+// valid for the same algorithm. Sub-keys for different contexts are
+// independent. The ID of a sub-key is given by DeriveSubKeyID.
+func DeriveSubKey(key []byte, context string) ([]byte, error) {
+	subKey := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(subKeyInfo+context)), subKey); err != nil {
+		return nil, errors.Join(ErrGeneratingKey, err)
+	}
+
+	return subKey, nil
+}
+
+// DeriveSubKeyID returns the ID of the sub-key for the named context of the
+// key with the given ID. It is derived from the key ID, not the key, so
+// reveals nothing about either key. As the envelope of every cipher text
+// records the ID of the key used, a cipher text encrypted under one context's
+// sub-key is rejected with ErrKeyMismatch when decrypted under another's.
+func DeriveSubKeyID(keyID KeyID, context string) KeyID {
+	h := sha256.New()
+	h.Write([]byte(subKeyIDLabel))
+	h.Write(keyID[:])
+	h.Write([]byte(context))
+
+	var id KeyID
+	copy(id[:], h.Sum(nil))
+	return id
+}
@@ -0,0 +1,43 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDeriveSubKey(t *testing.T) {
+	key := []byte("0123456789abcdefghijklmopqrstuvw")
+
+	billing, err := DeriveSubKey(key, "billing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, _ := DeriveSubKey(key, "billing")
+	payroll, _ := DeriveSubKey(key, "payroll")
+
+	if !ValidateAlgoKeyPair(AES256GCM, billing) {
+		t.Errorf("expected a valid %s key, got %d bytes", AES256GCM, len(billing))
+	}
+	if !bytes.Equal(billing, again) {
+		t.Error("expected the same context to derive the same sub-key")
+	}
+	if bytes.Equal(billing, payroll) || bytes.Equal(billing, key) {
+		t.Error("expected sub-keys to differ between contexts and from the key")
+	}
+
+	keyID := KeyID{1, 2, 3, 4}
+	billingID := DeriveSubKeyID(keyID, "billing")
+	payrollID := DeriveSubKeyID(keyID, "payroll")
+	if billingID != DeriveSubKeyID(keyID, "billing") {
+		t.Error("expected the same context to derive the same sub-key ID")
+	}
+	if billingID == payrollID || billingID == keyID {
+		t.Error("expected sub-key IDs to differ between contexts and from the key ID")
+	}
+
+	cipherText, _ := Encrypt(AES256GCM, billing, billingID, []byte("Get down!"), nil)
+	if _, err := Decrypt(AES256GCM, payroll, payrollID, cipherText, nil); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("expected ErrKeyMismatch decrypting under another context, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package sessionstore
+
+import (
+	"atostechtest/internal/datastore"
+	"atostechtest/internal/encryption"
+	"context"
+	"errors"
+	"slices"
+)
+
+// A session holds a keyring: a primary key, used to encrypt, and any number of
+// secondary keys, which can only decrypt. Every key is given a random
+// encryption.KeyID when added, stored alongside it. The ID is written into the
+// header of every cipher text (see the encryption package), so the key needed
+// to decrypt a cipher text can be picked from the keyring. Rotating a session
+// key is then a matter of adding a new primary key; cipher texts encrypted
+// under the previous primary key can still be decrypted.
+
+// MaxSessionKeys bounds the number of keys, the primary key included, a single
+// session may hold.
+const MaxSessionKeys = 16
+
+var (
+	// ErrKeyNotFound is returned when a session holds no key with the given
+	// key ID.
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrTooManyKeys is returned when adding a key to a session which already
+	// holds MaxSessionKeys keys.
+	ErrTooManyKeys = errors.New("session holds too many keys")
+)
+
+// Keys returns every key of the session, primary key first.
+func (s *Session) Keys() []string {
+	return append([]string{s.Key}, s.SecondaryKeys...)
+}
+
+// KeyIDs returns the key IDs of every key of the session, primary key first.
+func (s *Session) KeyIDs() []string {
+	ids := make([]string, 0, 1+len(s.SecondaryKeyIDs))
+	for _, id := range append([]encryption.KeyID{s.KeyID}, s.SecondaryKeyIDs...) {
+		ids = append(ids, id.String())
+	}
+	return ids
+}
+
+// EncryptionKey returns the key to encrypt with under the given context label,
+// along with its ID: the primary key, or for a non-empty label the sub-key
+// derived from it (see encryption.DeriveSubKey).
+func (s *Session) EncryptionKey(label string) (string, encryption.KeyID, error) {
+	return subKey(s.Key, s.KeyID, label)
+}
+
+// DecryptionKey returns the key a cipher text was encrypted with under the
+// given context label, along with its ID, as named by its envelope header; for
+// a non-empty label this is the sub-key derived from one of the session keys.
+// The primary key, or its sub-key, is returned for legacy cipher texts without
+// a header and for keys the session does not hold, in which case decryption
+// fails with encryption.ErrKeyMismatch.
+func (s *Session) DecryptionKey(cipherText []byte, label string) (string, encryption.KeyID, error) {
+	keys := s.Keys()
+	ids := append([]encryption.KeyID{s.KeyID}, s.SecondaryKeyIDs...)
+
+	if h, err := encryption.ParseHeader(cipherText); err == nil {
+		for i, id := range ids {
+			if label != "" {
+				id = encryption.DeriveSubKeyID(id, label)
+			}
+			if id == h.KeyID {
+				return subKey(keys[i], ids[i], label)
+			}
+		}
+	}
+	return subKey(keys[0], ids[0], label)
+}
+
+// subKey returns the sub-key of key for a context label along with its ID, or
+// key and id themselves if the label is empty.
+func subKey(key string, id encryption.KeyID, label string) (string, encryption.KeyID, error) {
+	if label == "" {
+		return key, id, nil
+	}
+	derived, err := encryption.DeriveSubKey([]byte(key), label)
+	return string(derived), encryption.DeriveSubKeyID(id, label), err
+}
+
+// AddKey adds a key to the keyring of a session and returns the updated
+// session. The key is given a new random key ID. If promote is true the key
+// becomes the primary key, used for all further encryption, and the previous
+// primary key can then only decrypt. Adding a key the session already holds is
+// not an error; it is promoted if asked. ErrTooManyKeys is returned if the
+// session already holds MaxSessionKeys keys. The remaining errors returned are
+// as for GetSession.
+func (s *Store) AddKey(ctx context.Context, id, key string, promote bool) (*Session, error) {
+	ctx, span := tracer.Start(ctx, "sessionstore.AddKey")
+	defer span.End()
+
+	return s.updateKeys(ctx, id, func(algorithm string, keys []string, stored []storedKey) ([]storedKey, int, error) {
+		i := slices.Index(keys, key)
+		if i < 0 {
+			if len(keys) >= MaxSessionKeys {
+				return nil, 0, ErrTooManyKeys
+			}
+			w, err := s.wrapKey(algorithm, key)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyID, err := newKeyID(stored)
+			if err != nil {
+				return nil, 0, err
+			}
+			stored = append(stored, storedKey{wrapped: w, id: keyID})
+			i = len(stored) - 1
+		}
+		if !promote {
+			i = 0
+		}
+		return stored, i, nil
+	})
+}
+
+// PromoteKey makes the key with the given key ID (see KeyIDs), in hex of
+// either case, the primary key of a session and returns the updated session.
+// ErrKeyNotFound is returned if the session holds no such key or the key ID is
+// not valid. The remaining errors returned are as for GetSession.
+func (s *Store) PromoteKey(ctx context.Context, id, keyID string) (*Session, error) {
+	ctx, span := tracer.Start(ctx, "sessionstore.PromoteKey")
+	defer span.End()
+
+	parsed, err := encryption.ParseKeyID(keyID)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+
+	return s.updateKeys(ctx, id, func(algorithm string, keys []string, stored []storedKey) ([]storedKey, int, error) {
+		for i, k := range stored {
+			if k.id == parsed {
+				return stored, i, nil
+			}
+		}
+		return nil, 0, ErrKeyNotFound
+	})
+}
+
+// storedKey is a session key as held at the data layer: wrapped, along with
+// its ID.
+type storedKey struct {
+	wrapped string
+	id      encryption.KeyID
+}
+
+// updateKeys applies update to the keyring of an unexpired session. update is
+// given the unwrapped keys of the session and the same keys as stored, both
+// primary key first, and returns the new stored keys along with the index of
+// the primary key among them; the remaining keys become secondary keys in
+// order.
+func (s *Store) updateKeys(ctx context.Context, id string, update func(algorithm string, keys []string, stored []storedKey) ([]storedKey, int, error)) (*Session, error) {
+	return s.updateSession(ctx, id, func(session *datastore.Session) error {
+		keys, err := s.unwrapKeys(session)
+		if err != nil {
+			s.logger.Error("unwrapping session key", "id", id, "err", err)
+			return err
+		}
+
+		ids := keyIDs(session)
+		stored := make([]storedKey, len(keys))
+		for i, wrapped := range append([]string{session.Key}, session.SecondaryKeys...) {
+			stored[i] = storedKey{wrapped: wrapped, id: ids[i]}
+		}
+		stored, primary, err := update(session.AlgorithmName, keys, stored)
+		if err != nil {
+			return err
+		}
+
+		// Build new slices as readers may share the stored ones.
+		session.Key, session.KeyID = stored[primary].wrapped, stored[primary].id.String()
+		session.SecondaryKeys, session.SecondaryKeyIDs = nil, nil
+		for i, k := range stored {
+			if i != primary {
+				session.SecondaryKeys = append(session.SecondaryKeys, k.wrapped)
+				session.SecondaryKeyIDs = append(session.SecondaryKeyIDs, k.id.String())
+			}
+		}
+		return nil
+	})
+}
+
+// unwrapKeys unwraps every key of a data layer session, primary key first.
+// Keys written before key wrapping was introduced are held in the clear and
+// returned as is.
+func (s *Store) unwrapKeys(session *datastore.Session) ([]string, error) {
+	keys := append([]string{session.Key}, session.SecondaryKeys...)
+	for i, key := range keys {
+		if !isWrapped(key) {
+			continue
+		}
+		var err error
+		if keys[i], _, err = s.unwrapKey(session.AlgorithmName, key); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// keyIDs returns the ID of every key of a data layer session, primary key
+// first. Keys stored without an ID, by sessions written before key IDs were
+// stored, have the zero ID, which newKeyID never returns.
+func keyIDs(session *datastore.Session) []encryption.KeyID {
+	stored := append([]string{session.KeyID}, session.SecondaryKeyIDs...)
+	ids := make([]encryption.KeyID, 1+len(session.SecondaryKeys))
+	for i := range ids {
+		if i < len(stored) {
+			ids[i], _ = encryption.ParseKeyID(stored[i]) // Zero if there is none.
+		}
+	}
+	return ids
+}
+
+// newKeyID returns a random key ID which is neither zero nor the ID of any of
+// the given keys.
+func newKeyID(stored []storedKey) (encryption.KeyID, error) {
+	for {
+		id, err := encryption.NewKeyID()
+		if err != nil {
+			return encryption.KeyID{}, err
+		}
+		if id != (encryption.KeyID{}) && !slices.ContainsFunc(stored, func(k storedKey) bool { return k.id == id }) {
+			return id, nil
+		}
+	}
+}
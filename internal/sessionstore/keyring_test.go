@@ -0,0 +1,191 @@
+package sessionstore
+
+import (
+	"atostechtest/internal/datastore"
+	"atostechtest/internal/encryption"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore_Keyring(t *testing.T) {
+	db := &mockDB{sessions: make(map[string]*datastore.Session)}
+	store := newTestStore(t, db, testMasterKey)
+
+	oldKey, newKey := "0123456789abcdef", "fedcba9876543210"
+	created, _ := store.NewSession(context.Background(), "", "aes128-gcm", oldKey, Limits{})
+	id := created.ID
+	oldCipherText, _ := encryption.Encrypt(encryption.AES128GCM, []byte(oldKey), created.KeyID, []byte("Ah-nold"), nil)
+	var newKeyID encryption.KeyID
+
+	t.Run("Add secondary key", func(t *testing.T) {
+		s, err := store.AddKey(context.Background(), id, newKey, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.Key != oldKey || !slices.Equal(s.SecondaryKeys, []string{newKey}) {
+			t.Errorf("expected primary key %q and secondary key %q, got %q and %q",
+				oldKey,
+				newKey,
+				s.Key,
+				s.SecondaryKeys)
+		}
+		for _, key := range db.sessions[id].SecondaryKeys {
+			if strings.Contains(key, newKey) {
+				t.Errorf("expected secondary key to be wrapped at rest, got %q", key)
+			}
+		}
+
+		if len(s.SecondaryKeyIDs) != 1 || s.KeyID != created.KeyID || s.SecondaryKeyIDs[0] == s.KeyID {
+			t.Fatalf("expected the primary key ID to be kept and a new one given, got %s and %s", s.KeyID, s.SecondaryKeyIDs)
+		}
+		newKeyID = s.SecondaryKeyIDs[0]
+		if !slices.Equal(db.sessions[id].SecondaryKeyIDs, []string{newKeyID.String()}) {
+			t.Errorf("expected secondary key ID %s to be stored, got %q", newKeyID, db.sessions[id].SecondaryKeyIDs)
+		}
+	})
+
+	t.Run("Promote key", func(t *testing.T) {
+		s, err := store.PromoteKey(context.Background(), id, newKeyID.String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.Key != newKey || !slices.Equal(s.SecondaryKeys, []string{oldKey}) {
+			t.Errorf("expected primary key %q and secondary key %q, got %q and %q",
+				newKey,
+				oldKey,
+				s.Key,
+				s.SecondaryKeys)
+		}
+		if s.KeyID != newKeyID || !slices.Equal(s.SecondaryKeyIDs, []encryption.KeyID{created.KeyID}) {
+			t.Errorf("expected key IDs to move with their keys, got %s and %s", s.KeyID, s.SecondaryKeyIDs)
+		}
+
+		s, _ = store.GetSession(context.Background(), id)
+		if key, keyID, _ := s.DecryptionKey(oldCipherText, ""); key != oldKey || keyID != created.KeyID {
+			t.Errorf("expected old cipher text to be decrypted with key %q, got %q", oldKey, key)
+		}
+	})
+
+	t.Run("Adding a held key promotes it", func(t *testing.T) {
+		s, err := store.AddKey(context.Background(), id, oldKey, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.Key != oldKey || len(s.SecondaryKeys) != 1 {
+			t.Errorf("expected primary key %q and one secondary key, got %q and %q",
+				oldKey,
+				s.Key,
+				s.SecondaryKeys)
+		}
+	})
+
+	t.Run("Upper case key ID", func(t *testing.T) {
+		s, err := store.PromoteKey(context.Background(), id, strings.ToUpper(newKeyID.String()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.KeyID != newKeyID {
+			t.Errorf("expected key %s to be promoted, got %s", newKeyID, s.KeyID)
+		}
+	})
+
+	t.Run("Unknown key ID", func(t *testing.T) {
+		for _, keyID := range []string{"00000000", "not hex!", ""} {
+			if _, err := store.PromoteKey(context.Background(), id, keyID); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("expected ErrKeyNotFound for %q, got %v", keyID, err)
+			}
+		}
+	})
+
+	t.Run("Too many keys", func(t *testing.T) {
+		var err error
+		for i := 0; i < MaxSessionKeys && err == nil; i++ {
+			_, err = store.AddKey(context.Background(), id, fmt.Sprintf("%016d", i), false)
+		}
+		if !errors.Is(err, ErrTooManyKeys) {
+			t.Errorf("expected ErrTooManyKeys, got %v", err)
+		}
+	})
+}
+
+func TestStore_KeyIDs(t *testing.T) {
+	db := &mockDB{sessions: make(map[string]*datastore.Session)}
+	store := newTestStore(t, db, testMasterKey)
+
+	t.Run("Random", func(t *testing.T) {
+		first, _ := store.NewSession(context.Background(), "", "aes128-gcm", "0123456789abcdef", Limits{})
+		second, _ := store.NewSession(context.Background(), "", "aes128-gcm", "0123456789abcdef", Limits{})
+		if first.KeyID == second.KeyID || first.KeyID == (encryption.KeyID{}) {
+			t.Errorf("expected distinct random key IDs for the same key, got %s and %s", first.KeyID, second.KeyID)
+		}
+	})
+
+	t.Run("Session without key IDs", func(t *testing.T) {
+		db.sessions["legacy"] = &datastore.Session{
+			AlgorithmName: "aes128-gcm",
+			Key:           "0123456789abcdef",
+			ExpiresAt:     time.Now().Add(time.Hour),
+		}
+
+		s, err := store.AddKey(context.Background(), "legacy", "fedcba9876543210", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ids := s.KeyIDs(); len(ids) != 2 || ids[0] != "00000000" || ids[1] == ids[0] {
+			t.Errorf("expected the zero key ID for the existing key and a new one for the added key, got %q", ids)
+		}
+	})
+}
+
+func TestSession_ContextKeys(t *testing.T) {
+	s := &Session{
+		Key:             "0123456789abcdef",
+		KeyID:           encryption.KeyID{1, 2, 3, 4},
+		SecondaryKeys:   []string{"fedcba9876543210"},
+		SecondaryKeyIDs: []encryption.KeyID{{4, 3, 2, 1}},
+	}
+
+	if key, keyID, _ := s.EncryptionKey(""); key != s.Key || keyID != s.KeyID {
+		t.Errorf("expected the primary key without a context, got %q", key)
+	}
+
+	secondary, _ := encryption.DeriveSubKey([]byte("fedcba9876543210"), "billing")
+	secondaryID := encryption.DeriveSubKeyID(encryption.KeyID{4, 3, 2, 1}, "billing")
+	cipherText, _ := encryption.Encrypt(encryption.AES128GCM, secondary, secondaryID, []byte("Ah-nold"), nil)
+	if key, keyID, _ := s.DecryptionKey(cipherText, "billing"); key != string(secondary) || keyID != secondaryID {
+		t.Errorf("expected the billing sub-key of the secondary key, got %q", key)
+	}
+
+	primary, primaryID, _ := s.EncryptionKey("payroll")
+	if key, keyID, _ := s.DecryptionKey(cipherText, "payroll"); key != primary || keyID != primaryID {
+		t.Errorf("expected the payroll sub-key of the primary key for an unknown key ID, got %q", key)
+	}
+}
+
+func TestStore_KeyringMasterKeyRotation(t *testing.T) {
+	db := &mockDB{sessions: make(map[string]*datastore.Session)}
+	newMasterKey := []byte("vutsrqpomlkjihgfedcba9876543210!")
+
+	oldStore := newTestStore(t, db, testMasterKey)
+	created, _ := oldStore.NewSession(context.Background(), "", "aes128", "0123456789abcdef", Limits{})
+	oldStore.AddKey(context.Background(), created.ID, "fedcba9876543210", false)
+
+	store := newTestStore(t, db, newMasterKey, testMasterKey)
+	if _, err := store.RewrapSessions(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store = newTestStore(t, db, newMasterKey)
+	s, err := store.GetSession(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("expected every key to be rewrapped, got %v", err)
+	}
+	if !slices.Equal(s.SecondaryKeys, []string{"fedcba9876543210"}) {
+		t.Errorf("expected secondary key to survive rewrap, got %q", s.SecondaryKeys)
+	}
+}
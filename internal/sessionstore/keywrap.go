@@ -0,0 +1,168 @@
+package sessionstore
+
+import (
+	"atostechtest/internal/encryption"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Session keys are never written to the data store in the clear. Instead they
+// are wrapped (encrypted) with a master key-encryption-key (KEK) using
+// AES-256-GCM, with the session algorithm as additional authenticated data,
+// and stored as:
+//
+//	kek:<master key ID>:<base64 nonce and sealed key>
+//
+// The master key ID is derived from the master key itself so that keys
+// wrapped before a rotation can be matched to the previous master key.
+
+const (
+	wrappedKeyPrefix = "kek"
+	masterKeyAlgo    = encryption.AES256GCM
+	masterKeySize    = 32
+)
+
+var (
+	// ErrInvalidMasterKey indicates a master key which is not a base64 or
+	// hex encoded 32 byte key.
+	ErrInvalidMasterKey = errors.New("master key must be a base64 or hex encoded 32 byte key")
+
+	// ErrUnknownMasterKey indicates a session key wrapped with a master key
+	// the store has not been given.
+	ErrUnknownMasterKey = errors.New("session key wrapped with unknown master key")
+
+	// ErrKeyUnwrap indicates a wrapped session key which could not be
+	// decrypted.
+	ErrKeyUnwrap = errors.New("could not unwrap session key")
+)
+
+// ParseMasterKey decodes a base64 or hex encoded 32 byte master key.
+// Surrounding whitespace, such as a trailing newline in a key file, is
+// ignored.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == masterKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == masterKeySize {
+		return key, nil
+	}
+	return nil, ErrInvalidMasterKey
+}
+
+// LoadMasterKeyFile reads and parses a master key from a file.
+func LoadMasterKeyFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseMasterKey(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// masterKeyID returns a short, stable identifier for a master key. It does
+// not reveal anything useful about the key.
+func masterKeyID(masterKey []byte) string {
+	sum := sha256.Sum256(append([]byte("sessionstore master key id:"), masterKey...))
+	return hex.EncodeToString(sum[:4])
+}
+
+// wrapKey encrypts a session key with the current master key. The master key
+// is named by the prefix of the wrapped key, so the envelope's key ID is left
+// zero.
+func (s *Store) wrapKey(algorithm, key string) (string, error) {
+	sealed, err := encryption.Encrypt(masterKeyAlgo,
+		s.masterKeys[s.currentMasterKeyID],
+		encryption.KeyID{},
+		[]byte(key),
+		[]byte(algorithm))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		wrappedKeyPrefix,
+		s.currentMasterKeyID,
+		base64.StdEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// unwrapKey reverses wrapKey, using whichever master key the session key was
+// wrapped with. It also returns the ID of that master key.
+func (s *Store) unwrapKey(algorithm, wrapped string) (string, string, error) {
+	parts := strings.SplitN(wrapped, ":", 3)
+	if len(parts) != 3 || parts[0] != wrappedKeyPrefix {
+		return "", "", ErrKeyUnwrap
+	}
+
+	masterKey, ok := s.masterKeys[parts[1]]
+	if !ok {
+		return "", "", ErrUnknownMasterKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", errors.Join(ErrKeyUnwrap, err)
+	}
+
+	// Keys wrapped before the envelope's key ID was left zero carry another,
+	// so accept whichever the envelope records.
+	h, _ := encryption.ParseHeader(sealed)
+	key, err := encryption.Decrypt(masterKeyAlgo, masterKey, h.KeyID, sealed, []byte(algorithm))
+	if err != nil {
+		return "", "", errors.Join(ErrKeyUnwrap, err)
+	}
+
+	return string(key), parts[1], nil
+}
+
+// isWrapped returns true if the stored key looks like a wrapped key. Sessions
+// written before key wrapping was introduced hold their key in the clear.
+func isWrapped(key string) bool {
+	return strings.HasPrefix(key, wrappedKeyPrefix+":")
+}
+
+// RewrapSessions re-encrypts every stored session key which is not wrapped
+// with the current master key. It should be run after a master key rotation,
+// with the outgoing master key passed to New as a previous master key, and
+// also wraps any keys stored in the clear by earlier versions. Sessions
+// wrapped with an unknown master key are left untouched. The number of
+// sessions updated is returned.
+func (s *Store) RewrapSessions(ctx context.Context) (int, error) {
+	ctx, span := tracer.Start(ctx, "sessionstore.RewrapSessions")
+	defer span.End()
+
+	n, err := s.db.RewrapKeys(ctx, func(algorithm, key string) (string, error) {
+		if isWrapped(key) {
+			unwrapped, id, err := s.unwrapKey(algorithm, key)
+			if err != nil {
+				s.logger.Warn("skipping session key which cannot be unwrapped", "err", err)
+				return key, nil
+			}
+			if id == s.currentMasterKeyID {
+				return key, nil
+			}
+			key = unwrapped
+		}
+		return s.wrapKey(algorithm, key)
+	})
+	if err != nil {
+		s.logger.Error("rewrapping session keys", "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return n, ErrDatabaseError
+	}
+
+	s.logger.Info("rewrapped session keys", "updated", n)
+	return n, nil
+}
@@ -0,0 +1,100 @@
+package sessionstore
+
+import (
+	"atostechtest/internal/datastore"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseMasterKey(t *testing.T) {
+	testCases := []struct {
+		name    string
+		encoded string
+		valid   bool
+	}{
+		{"Hex", hex.EncodeToString(testMasterKey), true},
+		{"Base64", base64.StdEncoding.EncodeToString(testMasterKey), true},
+		{"Base64 with newline", base64.StdEncoding.EncodeToString(testMasterKey) + "\n", true},
+		{"Too short", hex.EncodeToString(testMasterKey[:16]), false},
+		{"Not encoded", string(testMasterKey), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := ParseMasterKey(tc.encoded)
+			if tc.valid && (err != nil || string(key) != string(testMasterKey)) {
+				t.Errorf("expected key to parse, got %q, %v", key, err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidMasterKey) {
+				t.Errorf("expected ErrInvalidMasterKey, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_MasterKeyRotation(t *testing.T) {
+	db := &mockDB{sessions: make(map[string]*datastore.Session)}
+	oldMasterKey := testMasterKey
+	newMasterKey := []byte("vutsrqpomlkjihgfedcba9876543210!")
+
+	oldStore := newTestStore(t, db, oldMasterKey)
+	created, _ := oldStore.NewSession(context.Background(), "", "aes128", "0123456789abcdef", Limits{})
+	id := created.ID
+
+	// A legacy session with its key stored in the clear.
+	legacyID, _ := db.WriteSession(context.Background(), &datastore.Session{
+		AlgorithmName: "des",
+		Key:           "01234567",
+		ExpiresAt:     time.Now().Add(time.Hour),
+	})
+
+	t.Run("New master key alone cannot unwrap", func(t *testing.T) {
+		store := newTestStore(t, db, newMasterKey)
+		if _, err := store.GetSession(context.Background(), id); !errors.Is(err, ErrUnknownMasterKey) {
+			t.Errorf("expected ErrUnknownMasterKey, got %v", err)
+		}
+	})
+
+	store := newTestStore(t, db, newMasterKey, oldMasterKey)
+	updated, err := store.RewrapSessions(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error rewrapping sessions: %v", err)
+	}
+	if updated != 2 {
+		t.Errorf("expected 2 sessions to be rewrapped, got %d", updated)
+	}
+
+	t.Run("Rewrapped sessions readable without old master key", func(t *testing.T) {
+		store := newTestStore(t, db, newMasterKey)
+		for id, want := range map[string]string{id: "0123456789abcdef", legacyID: "01234567"} {
+			s, err := store.GetSession(context.Background(), id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s.Key != want {
+				t.Errorf("expected key %q, got %q", want, s.Key)
+			}
+		}
+	})
+
+	t.Run("Rewrapping again is a no-op", func(t *testing.T) {
+		if updated, _ := store.RewrapSessions(context.Background()); updated != 0 {
+			t.Errorf("expected no sessions to be rewrapped, got %d", updated)
+		}
+	})
+}
+
+func TestStore_WrappedKeyBoundToAlgorithm(t *testing.T) {
+	db := &mockDB{sessions: make(map[string]*datastore.Session)}
+	store := newTestStore(t, db, testMasterKey)
+	created, _ := store.NewSession(context.Background(), "", "aes128", "0123456789abcdef", Limits{})
+
+	db.sessions[created.ID].AlgorithmName = "des"
+	if _, err := store.GetSession(context.Background(), created.ID); !errors.Is(err, ErrKeyUnwrap) {
+		t.Errorf("expected ErrKeyUnwrap, got %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package sessionstore
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sessionsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "atostechtest",
+		Subsystem: "sessionstore",
+		Name:      "sessions_created_total",
+		Help:      "Number of sessions created.",
+	})
+
+	// Lookup results are one of found, expired, not_found or error.
+	sessionLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "atostechtest",
+		Subsystem: "sessionstore",
+		Name:      "session_lookups_total",
+		Help:      "Number of session lookups by result.",
+	}, []string{"result"})
+)
@@ -1,16 +1,27 @@
 // Package sessionstore provides types and methods for managing encryption
 // sessions. An encryption session encapsulates an algorithm, a key and an
-// expiry time. Note that this package is not responsible for cleaning up
-// expired sessions; that responsibility lies upstream in the data layer.
+// expiry time. Session keys are encrypted with a master key before they reach
+// the data layer (see keywrap.go). Note that this package is not responsible
+// for cleaning up expired sessions; that responsibility lies upstream in the
+// data layer.
 package sessionstore
 
 import (
 	"atostechtest/internal/datastore"
+	"atostechtest/internal/encryption"
+	"context"
 	"errors"
 	"log/slog"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("atostechtest/internal/sessionstore")
+
 var (
 	// ErrDatabaseError indicates a problem communicating with the database.
 	ErrDatabaseError = errors.New("database error")
@@ -20,49 +31,130 @@ var (
 
 	// ErrSessionNotFound is returned when a session does not exist.
 	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrUsageLimitExceeded is returned when a session has too little usage
+	// left for the operations asked of it.
+	ErrUsageLimitExceeded = errors.New("session usage limit exceeded")
 )
 
 // Session encapsulates a session object.
 type Session struct {
+	ID            string
+	Owner         string // The principal which created the session, if any.
 	AlgorithmName string
-	Key           string
-	ExpiresAt     time.Time
+	Key           string   // The primary key, used to encrypt.
+	SecondaryKeys []string // Further keys, which can only decrypt; see AddKey.
+
+	// The IDs of the primary and secondary keys, in the same order.
+	KeyID           encryption.KeyID
+	SecondaryKeyIDs []encryption.KeyID
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Limits
+	UsageCount     int64
+	BytesProcessed int64
+}
+
+// Limits bounds the life and use of a session. Once any usage limit is
+// reached the session is treated as expired. Zero values select the default
+// TTL and no usage limits respectively.
+type Limits struct {
+	TTL           time.Duration // Capped at the store's maximum session age.
+	MaxOperations int64         // Maximum number of encrypt and decrypt operations.
+	MaxBytes      int64         // Maximum number of input bytes encrypted or decrypted.
+}
+
+// Usage is a number of encrypt or decrypt operations on a number of input
+// bytes in total, as counted against the usage limits of a session.
+type Usage struct {
+	Operations int64
+	Bytes      int64
 }
 
 // Store manages the creation and retrieval of session objects. The zero value
-// is not ready to use, create new instances with the NewStore() function.
+// is not ready to use, create new instances with the New() function.
 type Store struct {
 	db            datastore.DB
 	stopChan      chan bool
 	logger        *slog.Logger
 	maxSessionAge time.Duration
+
+	// Master keys used to wrap session keys at rest, keyed by master key ID.
+	masterKeys         map[string][]byte
+	currentMasterKeyID string
 }
 
-// New takes an implementation of the datastore.DB interface and returns a
-// pointer to Store.
-func New(db datastore.DB, maxSessionAge time.Duration) *Store {
+// New takes an implementation of the datastore.DB interface, the maximum (and
+// default) session TTL and the master key used to wrap session keys before
+// they are written to it, and returns a pointer to Store. Any previous master
+// keys are only used to unwrap session keys written before a master key
+// rotation; see RewrapSessions. Master keys must be 32 bytes long.
+func New(db datastore.DB, maxSessionAge time.Duration, masterKey []byte, previousMasterKeys ...[]byte) (*Store, error) {
 	logger := slog.Default().With("component", "sessionstore")
 	s := &Store{
 		db:            db,
 		stopChan:      make(chan bool),
 		maxSessionAge: maxSessionAge,
 		logger:        logger,
+		masterKeys:    make(map[string][]byte),
 	}
 
-	return s
+	for _, key := range append(previousMasterKeys, masterKey) {
+		if len(key) != masterKeySize {
+			return nil, ErrInvalidMasterKey
+		}
+		s.masterKeys[masterKeyID(key)] = key
+	}
+	s.currentMasterKeyID = masterKeyID(masterKey)
+
+	return s, nil
 }
 
-// NewSession attempts to create a new session with a given algorithm and key
-// in the underlying data store. If there are any issues communicating with
-// database an ErrDatabaseError is returned. On successful session
-// creation a session ID is returned.
-func (s *Store) NewSession(algorithm, key string) (string, error) {
-	id, err := s.db.WriteSession(algorithm, key)
+// NewSession attempts to create a new session, owned by the given principal,
+// with a given algorithm, key and limits in the underlying data store. A TTL
+// above the maximum session age is capped. The key is wrapped with the current
+// master key before it is stored. If there are any issues communicating with
+// database an ErrDatabaseError is returned. On successful session creation the
+// new session is returned.
+func (s *Store) NewSession(ctx context.Context, owner, algorithm, key string, limits Limits) (*Session, error) {
+	ctx, span := tracer.Start(ctx, "sessionstore.NewSession",
+		trace.WithAttributes(attribute.String("session.algorithm", algorithm)))
+	defer span.End()
+
+	wrapped, err := s.wrapKey(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := newKeyID(nil)
 	if err != nil {
-		return "", ErrDatabaseError
+		return nil, err
 	}
 
-	return id, nil
+	now := time.Now().UTC()
+	session := &datastore.Session{
+		Owner:         owner,
+		AlgorithmName: algorithm,
+		Key:           wrapped,
+		KeyID:         keyID.String(),
+		CreatedAt:     now,
+		TTL:           s.ttl(limits.TTL),
+		MaxOperations: limits.MaxOperations,
+		MaxBytes:      limits.MaxBytes,
+	}
+	session.ExpiresAt = now.Add(session.TTL)
+
+	id, err := s.db.WriteSession(ctx, session)
+	if err != nil {
+		s.logger.Error("writing session to data store", "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, ErrDatabaseError
+	}
+	sessionsCreated.Inc()
+
+	created := *session
+	created.Key = key
+	return toSession(id, &created), nil
 }
 
 // GetSession takes a session ID and performs a session look up in the
@@ -70,25 +162,237 @@ func (s *Store) NewSession(algorithm, key string) (string, error) {
 // returns an error. If the session is not found an ErrSessionNotFound is
 // returned. If the session has expired an ErrSessionExpired is returned. If
 // there are any issues communicating with database an ErrDatabaseError is
-// returned.
-func (s *Store) GetSession(id string) (*Session, error) {
-	session, err := s.db.ReadSession(id)
+// returned. A session which has reached any of its usage limits is treated as
+// expired. If the session key cannot be unwrapped ErrUnknownMasterKey or
+// ErrKeyUnwrap is returned.
+func (s *Store) GetSession(ctx context.Context, id string) (*Session, error) {
+	ctx, span := tracer.Start(ctx, "sessionstore.GetSession")
+	defer span.End()
+
+	result := func(r string) {
+		sessionLookups.WithLabelValues(r).Inc()
+		span.SetAttributes(attribute.String("session.lookup_result", r))
+	}
+
+	session, err := s.db.ReadSession(ctx, id)
 	if err != nil {
 		s.logger.Error("retrieving session from data store",
 			"id", id,
 			"err", err)
+		result("error")
+		span.SetStatus(codes.Error, err.Error())
 		return nil, ErrDatabaseError
 	}
 	if session == nil {
+		result("not_found")
 		return nil, ErrSessionNotFound
 	}
-	expiresAt := session.CreatedAt.Add(s.maxSessionAge)
-	if expiresAt.Before(time.Now()) {
+	if session.Expired(time.Now()) {
+		result("expired")
 		return nil, ErrSessionExpired
 	}
+
+	result("found")
+	return s.fromDatastore(id, session)
+}
+
+// RefreshSession extends the life of a session, which then expires its TTL
+// from now rather than from its creation or previous refresh. The refreshed
+// session is returned. The errors returned are as for GetSession; an expired
+// or exhausted session cannot be refreshed.
+func (s *Store) RefreshSession(ctx context.Context, id string) (*Session, error) {
+	ctx, span := tracer.Start(ctx, "sessionstore.RefreshSession")
+	defer span.End()
+
+	return s.updateSession(ctx, id, func(session *datastore.Session) error {
+		session.TTL = s.ttl(session.TTL)
+		session.ExpiresAt = time.Now().UTC().Add(session.TTL)
+		return nil
+	})
+}
+
+// ReserveUsage reserves a number of operations on input bytes against the
+// usage limits of a session before they are carried out. The check and the
+// reservation are made in a single update, so concurrent requests cannot
+// between them exceed the limits. ErrUsageLimitExceeded is returned, and
+// nothing reserved, if the usage, along with that already counted or reserved,
+// would take the session past any of its limits. Reserved usage is held apart
+// from that counted, so the session is not treated as exhausted, and cleaned
+// up, until SettleUsage is called once the operations are done. The remaining
+// errors returned are as for GetSession.
+func (s *Store) ReserveUsage(ctx context.Context, id string, u Usage) error {
+	ctx, span := tracer.Start(ctx, "sessionstore.ReserveUsage")
+	defer span.End()
+
+	_, err := s.updateSession(ctx, id, func(session *datastore.Session) error {
+		if (session.MaxOperations > 0 && session.UsageCount+session.PendingOperations+u.Operations > session.MaxOperations) ||
+			(session.MaxBytes > 0 && session.BytesProcessed+session.PendingBytes+u.Bytes > session.MaxBytes) {
+			return ErrUsageLimitExceeded
+		}
+		session.PendingOperations += u.Operations
+		session.PendingBytes += u.Bytes
+		return nil
+	})
+	return err
+}
+
+// ReserveStream reserves one operation on all the input bytes a session has
+// left, for a stream whose length is not known until it ends, and returns the
+// number of bytes reserved; zero if the session has no byte limit, in which
+// case the bytes are only counted by SettleUsage. The errors returned are as
+// for ReserveUsage.
+func (s *Store) ReserveStream(ctx context.Context, id string) (int64, error) {
+	ctx, span := tracer.Start(ctx, "sessionstore.ReserveStream")
+	defer span.End()
+
+	var reserved int64
+	_, err := s.updateSession(ctx, id, func(session *datastore.Session) error {
+		if session.MaxOperations > 0 && session.UsageCount+session.PendingOperations+1 > session.MaxOperations {
+			return ErrUsageLimitExceeded
+		}
+		if session.MaxBytes > 0 {
+			reserved = session.MaxBytes - session.BytesProcessed - session.PendingBytes
+			if reserved <= 0 {
+				return ErrUsageLimitExceeded
+			}
+		}
+		session.PendingOperations++
+		session.PendingBytes += reserved
+		return nil
+	})
+	return reserved, err
+}
+
+// SettleUsage releases usage reserved with ReserveUsage or ReserveStream and
+// counts the usage actually made in its place, once known: operations which
+// failed, and bytes which were not read, are given back. Unlike a reservation
+// this is applied to sessions which have reached their usage limits. If there
+// are any issues communicating with database an ErrDatabaseError is returned.
+func (s *Store) SettleUsage(ctx context.Context, id string, reserved, used Usage) error {
+	ctx, span := tracer.Start(ctx, "sessionstore.SettleUsage")
+	defer span.End()
+
+	_, err := s.db.UpdateSession(ctx, id, func(session *datastore.Session) error {
+		session.PendingOperations = max(session.PendingOperations-reserved.Operations, 0)
+		session.PendingBytes = max(session.PendingBytes-reserved.Bytes, 0)
+		session.UsageCount += used.Operations
+		session.BytesProcessed += used.Bytes
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("updating session in data store",
+			"id", id,
+			"err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return ErrDatabaseError
+	}
+	return nil
+}
+
+// DeleteSession revokes a session immediately by removing it from the
+// underlying data store. If there are any issues communicating with database
+// an ErrDatabaseError is returned.
+func (s *Store) DeleteSession(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "sessionstore.DeleteSession")
+	defer span.End()
+
+	if err := s.db.DeleteSession(ctx, id); err != nil {
+		s.logger.Error("deleting session from data store",
+			"id", id,
+			"err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return ErrDatabaseError
+	}
+	return nil
+}
+
+// Ping checks that the underlying data store can serve requests. If it
+// cannot, the reason is logged and an ErrDatabaseError is returned.
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "sessionstore.Ping")
+	defer span.End()
+
+	if err := s.db.Ping(ctx); err != nil {
+		s.logger.Warn("pinging data store", "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return ErrDatabaseError
+	}
+	return nil
+}
+
+// updateSession applies update to an unexpired session in the data store and
+// returns the result. If update returns an error the session is left unchanged
+// and the error is returned.
+func (s *Store) updateSession(ctx context.Context, id string, update func(*datastore.Session) error) (*Session, error) {
+	var updateErr error
+	session, err := s.db.UpdateSession(ctx, id, func(session *datastore.Session) error {
+		if session.Expired(time.Now()) {
+			updateErr = ErrSessionExpired
+		} else {
+			updateErr = update(session)
+		}
+		return updateErr
+	})
+	if updateErr != nil {
+		return nil, updateErr
+	}
+	if err != nil {
+		s.logger.Error("updating session in data store",
+			"id", id,
+			"err", err)
+		trace.SpanFromContext(ctx).SetStatus(codes.Error, err.Error())
+		return nil, ErrDatabaseError
+	}
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	return s.fromDatastore(id, session)
+}
+
+// ttl returns the TTL to use for a session given the requested TTL, which is
+// defaulted and capped by the maximum session age.
+func (s *Store) ttl(requested time.Duration) time.Duration {
+	if requested <= 0 || requested > s.maxSessionAge {
+		return s.maxSessionAge
+	}
+	return requested
+}
+
+// fromDatastore converts a data layer session into a Session, unwrapping its
+// keys.
+func (s *Store) fromDatastore(id string, session *datastore.Session) (*Session, error) {
+	keys, err := s.unwrapKeys(session)
+	if err != nil {
+		s.logger.Error("unwrapping session key", "id", id, "err", err)
+		return nil, err
+	}
+
+	unwrapped := *session
+	unwrapped.Key, unwrapped.SecondaryKeys = keys[0], keys[1:]
+	return toSession(id, &unwrapped), nil
+}
+
+// toSession converts a data layer session, whose key must already be
+// unwrapped, into a Session.
+func toSession(id string, session *datastore.Session) *Session {
+	ids := keyIDs(session)
 	return &Session{
-		AlgorithmName: session.AlgorithmName,
-		Key:           session.Key,
-		ExpiresAt:     expiresAt,
-	}, nil
+		ID:              id,
+		Owner:           session.Owner,
+		AlgorithmName:   session.AlgorithmName,
+		Key:             session.Key,
+		SecondaryKeys:   session.SecondaryKeys,
+		KeyID:           ids[0],
+		SecondaryKeyIDs: ids[1:],
+		CreatedAt:       session.CreatedAt,
+		ExpiresAt:       session.ExpiresAt,
+		Limits: Limits{
+			TTL:           session.TTL,
+			MaxOperations: session.MaxOperations,
+			MaxBytes:      session.MaxBytes,
+		},
+		UsageCount:     session.UsageCount,
+		BytesProcessed: session.BytesProcessed,
+	}
 }
@@ -2,7 +2,12 @@ package sessionstore
 
 import (
 	"atostechtest/internal/datastore"
+	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -11,18 +16,14 @@ type mockDB struct {
 	sessions map[string]*datastore.Session
 }
 
-func (m *mockDB) WriteSession(algorithm, key string) (string, error) {
-	session := &datastore.Session{
-		AlgorithmName: algorithm,
-		Key:           key,
-		CreatedAt:     time.Now(),
-	}
-	id := "mock_session_id"
-	m.sessions[id] = session
+func (m *mockDB) WriteSession(ctx context.Context, s *datastore.Session) (string, error) {
+	session := *s
+	id := fmt.Sprintf("mock_session_id_%d", len(m.sessions))
+	m.sessions[id] = &session
 	return id, nil
 }
 
-func (m *mockDB) ReadSession(id string) (*datastore.Session, error) {
+func (m *mockDB) ReadSession(ctx context.Context, id string) (*datastore.Session, error) {
 	session, exists := m.sessions[id]
 	if !exists {
 		return nil, nil
@@ -30,60 +31,139 @@ func (m *mockDB) ReadSession(id string) (*datastore.Session, error) {
 	return session, nil
 }
 
+func (m *mockDB) UpdateSession(ctx context.Context, id string, update func(s *datastore.Session) error) (*datastore.Session, error) {
+	session, exists := m.sessions[id]
+	if !exists {
+		return nil, nil
+	}
+	updated := *session
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	m.sessions[id] = &updated
+	return &updated, nil
+}
+
+func (m *mockDB) DeleteSession(ctx context.Context, id string) error {
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *mockDB) CountSessions(ctx context.Context) (int, error) {
+	return len(m.sessions), nil
+}
+
+func (m *mockDB) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockDB) RewrapKeys(ctx context.Context, rewrap func(algorithm, key string) (string, error)) (int, error) {
+	var updated int
+	for _, s := range m.sessions {
+		changed, err := rewrapAll(s, rewrap)
+		if err != nil {
+			return updated, err
+		}
+		if changed {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// rewrapAll calls rewrap for every key of a session, replacing each key with
+// the result, and returns true if any key changed.
+func rewrapAll(s *datastore.Session, rewrap func(algorithm, key string) (string, error)) (bool, error) {
+	var changed bool
+	keys := []*string{&s.Key}
+	for i := range s.SecondaryKeys {
+		keys = append(keys, &s.SecondaryKeys[i])
+	}
+	for _, k := range keys {
+		key, err := rewrap(s.AlgorithmName, *k)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || key != *k
+		*k = key
+	}
+	return changed, nil
+}
+
+var testMasterKey = []byte("0123456789abcdefghijklmopqrstuvw")
+
+func newTestStore(t *testing.T, db datastore.DB, masterKey []byte, previous ...[]byte) *Store {
+	t.Helper()
+	store, err := New(db, time.Hour, masterKey, previous...)
+	if err != nil {
+		t.Fatalf("unexpected error creating store: %v", err)
+	}
+	return store
+}
+
 func TestStore_NewSession(t *testing.T) {
 	mockDB := &mockDB{sessions: make(map[string]*datastore.Session)}
-	store := New(mockDB, time.Hour)
+	store := newTestStore(t, mockDB, testMasterKey)
 	algorithm := "mock_algorithm"
 	key := "mock_key"
 
-	sessionID, err := store.NewSession(algorithm, key)
+	created, err := store.NewSession(context.Background(), "", algorithm, key, Limits{})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if sessionID == "" {
+	if created.ID == "" {
 		t.Error("expected non-empty session ID, got empty string")
 	}
+	if created.Key != key {
+		t.Errorf("expected created session with key %q, got %q", key, created.Key)
+	}
 
-	session, exists := mockDB.sessions[sessionID]
+	session, exists := mockDB.sessions[created.ID]
 	if !exists {
 		t.Error("expected session to exist")
 	}
 
-	if session.AlgorithmName != algorithm || session.Key != key {
-		t.Errorf("expected session with algorithm %q and key %q, got %v",
+	if session.AlgorithmName != algorithm {
+		t.Errorf("expected session with algorithm %q, got %v",
 			algorithm,
-			key,
 			session)
 	}
+	if session.Owner != "" {
+		t.Errorf("expected anonymous owner, got %q", session.Owner)
+	}
+	if strings.Contains(session.Key, key) {
+		t.Errorf("expected key to be wrapped at rest, got %q", session.Key)
+	}
 }
 
 func TestStore_GetSession(t *testing.T) {
 	mockDB := &mockDB{sessions: make(map[string]*datastore.Session)}
-	store := New(mockDB, time.Hour)
+	store := newTestStore(t, mockDB, testMasterKey)
 	algorithm := "mock_algorithm"
 	key := "mock_key"
 
-	sessionID, _ := store.NewSession(algorithm, key)
+	created, _ := store.NewSession(context.Background(), "", algorithm, key, Limits{})
+	sessionID := created.ID
 
 	t.Run("Session does not exist", func(t *testing.T) {
-		_, err := store.GetSession("non_existent_session_id")
+		_, err := store.GetSession(context.Background(), "non_existent_session_id")
 		if !errors.Is(err, ErrSessionNotFound) {
 			t.Errorf("expected ErrSessionNotFound, got %v", err)
 		}
 	})
 
 	t.Run("Session has expired", func(t *testing.T) {
-		mockDB.sessions[sessionID].CreatedAt = time.Now().Add(-time.Hour * 2)
-		_, err := store.GetSession(sessionID)
+		mockDB.sessions[sessionID].ExpiresAt = time.Now().Add(-time.Hour)
+		_, err := store.GetSession(context.Background(), sessionID)
 		if !errors.Is(err, ErrSessionExpired) {
 			t.Errorf("expected ErrSessionExpired, got %v", err)
 		}
 	})
 
 	t.Run("Session is valid", func(t *testing.T) {
-		mockDB.sessions[sessionID].CreatedAt = time.Now().Add(time.Hour * 2)
-		s, err := store.GetSession(sessionID)
+		mockDB.sessions[sessionID].ExpiresAt = time.Now().Add(time.Hour)
+		s, err := store.GetSession(context.Background(), sessionID)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -98,3 +178,225 @@ func TestStore_GetSession(t *testing.T) {
 		}
 	})
 }
+
+// use reserves and then settles usage of a session, as a successful request
+// does.
+func use(t *testing.T, store *Store, id string, u Usage) {
+	t.Helper()
+	if err := store.ReserveUsage(context.Background(), id, u); err != nil {
+		t.Fatalf("unexpected error reserving usage: %v", err)
+	}
+	if err := store.SettleUsage(context.Background(), id, u, u); err != nil {
+		t.Fatalf("unexpected error settling usage: %v", err)
+	}
+}
+
+func TestStore_SessionLifecycle(t *testing.T) {
+	mockDB := &mockDB{sessions: make(map[string]*datastore.Session)}
+	store := newTestStore(t, mockDB, testMasterKey)
+	created, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{})
+	sessionID := created.ID
+
+	t.Run("Record usage", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			use(t, store, sessionID, Usage{1, 10})
+		}
+		s, _ := store.GetSession(context.Background(), sessionID)
+		if s.UsageCount != 3 || s.BytesProcessed != 30 {
+			t.Errorf("expected usage count of 3 and 30 bytes processed, got %d and %d",
+				s.UsageCount,
+				s.BytesProcessed)
+		}
+	})
+
+	t.Run("Refresh extends expiry", func(t *testing.T) {
+		mockDB.sessions[sessionID].ExpiresAt = time.Now().Add(time.Minute * 10)
+		before, _ := store.GetSession(context.Background(), sessionID)
+
+		after, err := store.RefreshSession(context.Background(), sessionID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !after.ExpiresAt.After(before.ExpiresAt.Add(time.Minute * 49)) {
+			t.Errorf("expected expiry to move from %v to about an hour from now, got %v",
+				before.ExpiresAt,
+				after.ExpiresAt)
+		}
+		if !after.CreatedAt.Equal(before.CreatedAt) {
+			t.Error("expected refresh not to change creation time")
+		}
+	})
+
+	t.Run("Expired session cannot be refreshed", func(t *testing.T) {
+		mockDB.sessions[sessionID].ExpiresAt = time.Now().Add(-time.Hour)
+		if _, err := store.RefreshSession(context.Background(), sessionID); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("expected ErrSessionExpired, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.DeleteSession(context.Background(), sessionID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := store.GetSession(context.Background(), sessionID); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("expected ErrSessionNotFound, got %v", err)
+		}
+		if err := store.ReserveUsage(context.Background(), sessionID, Usage{1, 0}); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("expected ErrSessionNotFound, got %v", err)
+		}
+	})
+}
+
+func TestStore_SessionLimits(t *testing.T) {
+	mockDB := &mockDB{sessions: make(map[string]*datastore.Session)}
+	store := newTestStore(t, mockDB, testMasterKey)
+
+	t.Run("TTL defaults to maximum", func(t *testing.T) {
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{})
+		if s.TTL != time.Hour {
+			t.Errorf("expected TTL of %v, got %v", time.Hour, s.TTL)
+		}
+	})
+
+	t.Run("TTL is capped", func(t *testing.T) {
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{TTL: time.Hour * 24})
+		if s.TTL != time.Hour {
+			t.Errorf("expected TTL of %v, got %v", time.Hour, s.TTL)
+		}
+	})
+
+	t.Run("Short TTL", func(t *testing.T) {
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{TTL: time.Minute})
+		if d := time.Until(s.ExpiresAt); d > time.Minute || d < time.Second*59 {
+			t.Errorf("expected session to expire in about a minute, got %v", d)
+		}
+
+		refreshed, _ := store.RefreshSession(context.Background(), s.ID)
+		if refreshed.TTL != time.Minute {
+			t.Errorf("expected refresh to keep TTL of %v, got %v", time.Minute, refreshed.TTL)
+		}
+	})
+
+	t.Run("Operations exhausted", func(t *testing.T) {
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{MaxOperations: 2})
+		for i := 0; i < 2; i++ {
+			use(t, store, s.ID, Usage{1, 1})
+		}
+		if _, err := store.GetSession(context.Background(), s.ID); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("expected ErrSessionExpired, got %v", err)
+		}
+		if err := store.ReserveUsage(context.Background(), s.ID, Usage{1, 1}); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("expected ErrSessionExpired, got %v", err)
+		}
+	})
+
+	t.Run("Batch counts every operation", func(t *testing.T) {
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{MaxOperations: 3})
+		if err := store.ReserveUsage(context.Background(), s.ID, Usage{4, 40}); !errors.Is(err, ErrUsageLimitExceeded) {
+			t.Errorf("expected ErrUsageLimitExceeded, got %v", err)
+		}
+		use(t, store, s.ID, Usage{3, 30})
+		if _, err := store.GetSession(context.Background(), s.ID); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("expected ErrSessionExpired, got %v", err)
+		}
+	})
+
+	t.Run("Bytes exhausted", func(t *testing.T) {
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{MaxBytes: 100})
+		use(t, store, s.ID, Usage{1, 60})
+		if err := store.ReserveUsage(context.Background(), s.ID, Usage{1, 60}); !errors.Is(err, ErrUsageLimitExceeded) {
+			t.Errorf("expected ErrUsageLimitExceeded, got %v", err)
+		}
+		if _, err := store.GetSession(context.Background(), s.ID); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		use(t, store, s.ID, Usage{1, 40})
+		if _, err := store.GetSession(context.Background(), s.ID); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("expected ErrSessionExpired, got %v", err)
+		}
+	})
+
+	t.Run("Settle gives back unused usage", func(t *testing.T) {
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{MaxOperations: 2, MaxBytes: 100})
+		store.ReserveUsage(context.Background(), s.ID, Usage{2, 20})
+		if err := store.SettleUsage(context.Background(), s.ID, Usage{2, 20}, Usage{1, 10}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		s, err := store.GetSession(context.Background(), s.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.UsageCount != 1 || s.BytesProcessed != 10 {
+			t.Errorf("expected usage count of 1 and 10 bytes processed, got %d and %d",
+				s.UsageCount,
+				s.BytesProcessed)
+		}
+	})
+
+	t.Run("Stream reserves remaining bytes", func(t *testing.T) {
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{MaxBytes: 100})
+		use(t, store, s.ID, Usage{1, 30})
+		reserved, err := store.ReserveStream(context.Background(), s.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reserved != 70 {
+			t.Errorf("expected 70 bytes to be reserved, got %d", reserved)
+		}
+		if err := store.ReserveUsage(context.Background(), s.ID, Usage{1, 1}); !errors.Is(err, ErrUsageLimitExceeded) {
+			t.Errorf("expected the stream to hold every remaining byte, got %v", err)
+		}
+		if _, err := store.ReserveStream(context.Background(), s.ID); !errors.Is(err, ErrUsageLimitExceeded) {
+			t.Errorf("expected no bytes to be left for another stream, got %v", err)
+		}
+		// Bytes reserved are not yet used, so the session is not exhausted.
+		if _, err := store.GetSession(context.Background(), s.ID); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		store.SettleUsage(context.Background(), s.ID, Usage{1, reserved}, Usage{1, 20})
+		if s, _ := store.GetSession(context.Background(), s.ID); s == nil || s.BytesProcessed != 50 {
+			t.Errorf("expected 50 bytes processed, got %v", s)
+		}
+	})
+
+	t.Run("Concurrent reservations", func(t *testing.T) {
+		db := datastore.NewInMemory(time.Minute) // Safe for concurrent use, unlike mockDB.
+		defer db.Close()
+		store := newTestStore(t, db, testMasterKey)
+		s, _ := store.NewSession(context.Background(), "", "mock_algorithm", "mock_key", Limits{MaxOperations: 5})
+		var wg sync.WaitGroup
+		var reserved atomic.Int64
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if store.ReserveUsage(context.Background(), s.ID, Usage{1, 0}) == nil {
+					reserved.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if reserved.Load() != 5 {
+			t.Errorf("expected 5 of 20 concurrent reservations to succeed, got %d", reserved.Load())
+		}
+	})
+}
+
+func TestStore_SessionOwner(t *testing.T) {
+	mockDB := &mockDB{sessions: make(map[string]*datastore.Session)}
+	store := newTestStore(t, mockDB, testMasterKey)
+
+	created, err := store.NewSession(context.Background(), "etl", "mock_algorithm", "mock_key", Limits{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created.Owner != "etl" {
+		t.Errorf("expected owner %q, got %q", "etl", created.Owner)
+	}
+
+	s, _ := store.GetSession(context.Background(), created.ID)
+	if s.Owner != "etl" {
+		t.Errorf("expected stored owner %q, got %q", "etl", s.Owner)
+	}
+}
@@ -0,0 +1,214 @@
+// Package tlsconfig provides server TLS configuration, optionally requiring
+// client certificates (mutual TLS), which is reloaded whenever the
+// certificate, key or client CA files change on disk.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// reloadPollInterval is the frequency with which the certificate files
+	// are checked for changes. Not constant to allow for testing.
+	reloadPollInterval = time.Second * 10
+
+	// ErrNoClientCAs is returned when a client CA file holds no PEM
+	// encoded certificates.
+	ErrNoClientCAs = errors.New("no certificates found in client CA file")
+)
+
+// Reloader holds a server certificate, and optionally a pool of client CAs,
+// loaded from files which are watched for changes. The zero value is not
+// ready to use, create new instances with the New() function.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string // Empty if client certificates are not required.
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+
+	logger   *slog.Logger
+	stopChan chan bool
+}
+
+// New loads the certificate and key from certFile and keyFile, and if
+// clientCAFile is not empty the bundle of CAs used to verify client
+// certificates, and returns a new instance of Reloader. Calling this function
+// starts a routine which periodically checks the files for changes and
+// reloads them; if a reload fails the previous configuration is kept. Calling
+// Close() will shutdown this routine.
+func New(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		logger:       slog.Default().With("component", "tlsconfig"),
+		stopChan:     make(chan bool),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	go r.watch()
+
+	return r, nil
+}
+
+// TLSConfig returns a server TLS configuration which always uses the most
+// recently loaded certificate and client CAs. When a client CA file is
+// configured clients must present a certificate signed by one of its CAs.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.clientCAs != nil {
+				cfg.ClientCAs = r.clientCAs
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Close stops the file watching routine. The TLS configuration continues to
+// use the last loaded files.
+func (r *Reloader) Close() {
+	r.stopChan <- true
+}
+
+// ClientIdentity returns the identity of the verified client certificate of a
+// connection: its subject common name or, failing that, its first URI or DNS
+// subject alternative name. An empty string is returned if the connection is
+// not TLS or no client certificate was verified.
+func ClientIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+
+	cert := state.PeerCertificates[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+// files returns the paths of all watched files.
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// reload loads all the files, replacing the current configuration only if
+// every file is loaded successfully.
+func (r *Reloader) reload() error {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading client CAs: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return ErrNoClientCAs
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	return nil
+}
+
+// statFiles returns the modification times of the watched files.
+func (r *Reloader) statFiles() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+	return modTimes, nil
+}
+
+// changed returns true if any watched file has been modified since it was
+// last loaded.
+func (r *Reloader) changed() bool {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		// Files are often replaced rather than rewritten, so may briefly
+		// not exist; try again next time.
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := range modTimes {
+		if !modTimes[i].Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) watch() {
+	ticker := time.NewTicker(reloadPollInterval)
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case <-r.stopChan:
+			break loop
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				r.logger.Error("reloading certificates, keeping previous", "err", err)
+				continue
+			}
+			r.logger.Info("reloaded certificates")
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key, and their PEM encodings.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate with the given common name, signed by
+// parent or self signed if parent is nil.
+func newTestCert(t *testing.T, cn string, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("unexpected error creating certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatalf("unexpected error loading key pair: %v", err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("unexpected error writing %s: %v", path, err)
+	}
+}
+
+// newTestServer starts an HTTPS server using the reloader which responds with
+// the client identity.
+func newTestServer(t *testing.T, r *Reloader) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, ClientIdentity(req.TLS))
+	}))
+	srv.TLS = r.TLSConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReloader_HotReload(t *testing.T) {
+	reloadPollInterval = time.Millisecond * 50
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	first := newTestCert(t, "first", false, nil)
+	writeFile(t, certFile, first.certPEM)
+	writeFile(t, keyFile, first.keyPEM)
+
+	r, err := New(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("unexpected error creating reloader: %v", err)
+	}
+	defer r.Close()
+	srv := newTestServer(t, r)
+
+	serverCN := func() string {
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("unexpected error connecting: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	if cn := serverCN(); cn != "first" {
+		t.Fatalf("expected certificate %q, got %q", "first", cn)
+	}
+
+	t.Run("Invalid files are not loaded", func(t *testing.T) {
+		writeFile(t, certFile, []byte("not a certificate"))
+		time.Sleep(reloadPollInterval * 4)
+		if cn := serverCN(); cn != "first" {
+			t.Errorf("expected certificate %q to be kept, got %q", "first", cn)
+		}
+	})
+
+	t.Run("Changed files are reloaded", func(t *testing.T) {
+		second := newTestCert(t, "second", false, nil)
+		writeFile(t, keyFile, second.keyPEM)
+		writeFile(t, certFile, second.certPEM)
+		time.Sleep(reloadPollInterval * 4)
+		if cn := serverCN(); cn != "second" {
+			t.Errorf("expected certificate %q, got %q", "second", cn)
+		}
+	})
+}
+
+func TestReloader_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	ca := newTestCert(t, "test ca", true, nil)
+	server := newTestCert(t, "server", false, ca)
+	writeFile(t, certFile, server.certPEM)
+	writeFile(t, keyFile, server.keyPEM)
+	writeFile(t, caFile, ca.certPEM)
+
+	r, err := New(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("unexpected error creating reloader: %v", err)
+	}
+	defer r.Close()
+	srv := newTestServer(t, r)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCerts ...tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: clientCerts,
+		}}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	t.Run("Client certificate required", func(t *testing.T) {
+		if _, err := get(); err == nil {
+			t.Error("expected request without a client certificate to fail")
+		}
+	})
+
+	t.Run("Untrusted client certificate rejected", func(t *testing.T) {
+		untrusted := newTestCert(t, "mallory", false, nil)
+		if _, err := get(untrusted.tlsCertificate(t)); err == nil {
+			t.Error("expected request with an untrusted client certificate to fail")
+		}
+	})
+
+	t.Run("Client identity available", func(t *testing.T) {
+		client := newTestCert(t, "etl", false, ca)
+		identity, err := get(client.tlsCertificate(t))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if identity != "etl" {
+			t.Errorf("expected client identity %q, got %q", "etl", identity)
+		}
+	})
+}
+
+func TestNew_InvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert := newTestCert(t, "server", false, nil)
+	writeFile(t, certFile, cert.certPEM)
+	writeFile(t, keyFile, cert.keyPEM)
+
+	t.Run("Missing file", func(t *testing.T) {
+		if _, err := New(certFile, filepath.Join(dir, "missing.pem"), ""); err == nil {
+			t.Error("expected an error for a missing key file")
+		}
+	})
+
+	t.Run("Empty client CA file", func(t *testing.T) {
+		caFile := filepath.Join(dir, "ca.pem")
+		writeFile(t, caFile, nil)
+		if _, err := New(certFile, keyFile, caFile); err != ErrNoClientCAs {
+			t.Errorf("expected ErrNoClientCAs, got %v", err)
+		}
+	})
+}
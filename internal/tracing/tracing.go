@@ -0,0 +1,95 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are sent to an
+// exporter chosen by name; the built in exporters are none, which discards
+// spans, and stdout, which writes them as JSON. Further exporters, such as an
+// OTLP exporter, can be added with RegisterExporter.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// ServiceName identifies this service in exported spans.
+const ServiceName = "atostechtest"
+
+// ErrUnknownExporter is returned when an exporter name has not been
+// registered.
+var ErrUnknownExporter = errors.New("unknown trace exporter")
+
+// NewExporterFunc creates a span exporter.
+type NewExporterFunc func(ctx context.Context) (sdktrace.SpanExporter, error)
+
+var (
+	mu        sync.Mutex
+	exporters = map[string]NewExporterFunc{
+		"none": nil, // Spans are not recorded at all.
+		"stdout": func(context.Context) (sdktrace.SpanExporter, error) {
+			return stdouttrace.New()
+		},
+	}
+)
+
+// RegisterExporter makes an exporter available under name, replacing any
+// exporter already registered with that name. It should be called before
+// Setup.
+func RegisterExporter(name string, newExporter NewExporterFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporters[name] = newExporter
+}
+
+// Exporters returns the sorted names of all registered exporters.
+func Exporters() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var names []string
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Setup installs a global tracer provider which sends spans to the named
+// exporter, and the W3C trace context propagator so that traces may continue
+// from a caller. The returned function flushes any buffered spans and must be
+// called on shutdown. With the none exporter the default no-op tracer
+// provider is left in place.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	mu.Lock()
+	newExporter, ok := exporters[exporter]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExporter, exporter)
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	if newExporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exp, err := newExporter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s trace exporter: %w", exporter, err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
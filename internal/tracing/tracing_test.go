@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// recordingExporter keeps exported spans after shutdown, unlike
+// tracetest.InMemoryExporter.
+type recordingExporter struct {
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (e *recordingExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+func TestSetup(t *testing.T) {
+	t.Run("Unknown exporter", func(t *testing.T) {
+		if _, err := Setup(context.Background(), "carrier-pigeon"); !errors.Is(err, ErrUnknownExporter) {
+			t.Errorf("expected ErrUnknownExporter, got %v", err)
+		}
+	})
+
+	t.Run("None exporter", func(t *testing.T) {
+		shutdown, err := Setup(context.Background(), "none")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("unexpected error shutting down: %v", err)
+		}
+	})
+
+	t.Run("Registered exporter", func(t *testing.T) {
+		exporter := &recordingExporter{}
+		RegisterExporter("memory", func(context.Context) (sdktrace.SpanExporter, error) {
+			return exporter, nil
+		})
+
+		shutdown, err := Setup(context.Background(), "memory")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, span := otel.Tracer("test").Start(context.Background(), "test span")
+		span.End()
+		if err := shutdown(context.Background()); err != nil {
+			t.Fatalf("unexpected error shutting down: %v", err)
+		}
+
+		if len(exporter.spans) != 1 || exporter.spans[0].Name() != "test span" {
+			t.Errorf("expected the test span to be exported, got %d spans", len(exporter.spans))
+		}
+	})
+}
+
+func TestExporters(t *testing.T) {
+	names := Exporters()
+	for _, want := range []string{"none", "stdout"} {
+		var found bool
+		for _, name := range names {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("expected exporter %q to be registered, got %v", want, names)
+		}
+	}
+}